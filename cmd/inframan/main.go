@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/iivel-inc/inframan/internal/cli"
@@ -8,7 +9,11 @@ import (
 
 func main() {
 	if err := cli.Execute(); err != nil {
+		// Some commands report a result through the exit code (e.g. drift exits 2)
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
-
@@ -1,18 +1,19 @@
 # NixOS configuration module for the target machine
 # This is deployed via Colmena after infrastructure is provisioned
-{ config, pkgs, lib, ... }:
+# The inframan argument describes the project, this instance and its peers
+{ config, pkgs, lib, inframan, ... }:
 
 {
   # System basics
   system.stateVersion = "24.05";
 
-  # Boot configuration for AWS
+  # Boot configuration for AWS (or drop it and run 'inframan hardware' / 'deploy --fetch-hardware')
   boot.loader.grub.device = "nodev";
   boot.loader.grub.efiSupport = true;
   boot.loader.efi.canTouchEfiVariables = true;
 
   # Networking
-  networking.hostName = "inframan-node";
+  networking.hostName = inframan.instance;
   networking.firewall = {
     enable = true;
     allowedTCPPorts = [ 22 80 443 ];
@@ -27,7 +28,7 @@
     };
   };
 
-  # Root user SSH key (add your public key here)
+  # Additional root SSH keys (inframan adds the deploy key from SSH_KEY_PATH itself)
   users.users.root.openssh.authorizedKeys.keys = [
     # "ssh-ed25519 AAAA... your-key-here"
   ];
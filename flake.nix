@@ -28,7 +28,29 @@
       #                 Can be absolute path or relative to the project root
       #   - sshConfigPath: (Optional) Path to SSH config file for deployment and SSH access
       #                    Useful for multi-user setups where each user has different keys
-      lib.mkRunner = { system, infraConfig, machineConfig, projectName ? "default", sshKeyPath ? null, sshConfigPath ? null }:
+      #   - sshCAKeyPath: (Optional) Path to an SSH CA private key; when set, inframan signs
+      #                   short-lived user certificates instead of using sshKeyPath
+      #                   Must be a runtime path string, not a Nix path, which would copy the key into the store
+      #   - sshCertPrincipals: (Optional) List of principals for issued certificates (default: [ "root" ])
+      #   - sshCertTTL: (Optional) Lifetime of issued certificates, e.g. "30m" (default: "1h")
+      #   - recordSessions: (Optional) Record every `inframan ssh` session for audit (default: false)
+      #   - backend: (Optional) Managed Terraform backend, injected into the generated config.tf.json
+      #              e.g. { type = "s3"; config = { bucket = "my-state"; region = "eu-west-1"; }; }
+      #              The state key is derived from projectName (keyPrefix defaults to "inframan")
+      #   - snapshotRetention: (Optional) Number of state snapshots kept per project (default: 20)
+      #   - secrets: (Optional) Secrets shipped to hosts as Colmena deployment keys, by name
+      #              e.g. { "tls.key" = { file = "secrets/tls.key"; user = "nginx"; permissions = "0400"; }; }
+      #              Use strings, not Nix paths, so secret files are not copied into the store
+      #   - diskoConfig: (Optional) NixOS module with the disko disk layout for `inframan bootstrap`; it must
+      #                  import disko's NixOS module. Deploy then only targets bootstrapped instances.
+      #   - nixOutputs: (Optional) Terraform outputs passed to NixOS modules, e.g. [ "db_endpoint" ]
+      #                 Modules receive them as inframan.outputs (see README "Topology")
+      #   - deployer: (Optional) Deployment backend: "colmena" (default), "nixos-rebuild" or "deploy-rs"
+      #   - deployUser: (Optional) User the deployers log in as, acting as root through sudo (default "root")
+      lib.mkRunner = { system, infraConfig, machineConfig, projectName ? "default", sshKeyPath ? null, sshConfigPath ? null,
+                       sshCAKeyPath ? null, sshCertPrincipals ? null, sshCertTTL ? null, recordSessions ? false,
+                       backend ? null, snapshotRetention ? null, secrets ? null, diskoConfig ? null, nixOutputs ? [ ],
+                       deployer ? null, deployUser ? null }:
         let
           pkgs = import nixpkgs {
             config.allowUnfree = true;
@@ -53,6 +75,27 @@
           sshConfigExport = if sshConfigPath != null
             then ''export SSH_CONFIG_PATH="${sshConfigPath}"''
             else "";
+
+          # Managed backend export line (only if backend is provided)
+          backendExport = if backend != null
+            then ''export TF_BACKEND_JSON="${pkgs.writeText "inframan-backend.json" (builtins.toJSON backend)}"''
+            else "";
+
+          # Secrets declaration export line (only if secrets are provided)
+          secretsExport = if secrets != null
+            then ''export SECRETS_JSON="${pkgs.writeText "inframan-secrets.json" (builtins.toJSON secrets)}"''
+            else "";
+
+          # SSH certificate authority export lines (only if sshCAKeyPath is provided)
+          # A Nix path, or a string referring to one, would copy the CA private key into the world-readable store
+          sshCertExport = assert lib.assertMsg
+            (sshCAKeyPath == null || (builtins.isString sshCAKeyPath && !builtins.hasContext sshCAKeyPath))
+            "mkRunner: sshCAKeyPath must be a runtime path string such as \"/secrets/inframan-ca\", not a Nix path";
+            lib.concatStringsSep "\n" (
+            lib.optional (sshCAKeyPath != null) ''export SSH_CA_KEY_PATH="${sshCAKeyPath}"''
+            ++ lib.optional (sshCertPrincipals != null) ''export SSH_CERT_PRINCIPALS="${lib.concatStringsSep "," sshCertPrincipals}"''
+            ++ lib.optional (sshCertTTL != null) ''export SSH_CERT_TTL="${sshCertTTL}"''
+          );
         in
         pkgs.writeShellApplication {
           name = "runner";
@@ -60,6 +103,10 @@
             pkgs.terraform
             colmena.packages.${system}.colmena
             pkgs.nix
+            pkgs.openssh
+            pkgs.nixos-anywhere
+            pkgs.nixos-rebuild
+            pkgs.deploy-rs
           ];
           text = ''
             # Export environment variables for the Go tool
@@ -68,6 +115,17 @@
             export PROJECT_NAME="${projectName}"
             ${sshKeyExport}
             ${sshConfigExport}
+            ${sshCertExport}
+            ${lib.optionalString recordSessions ''export SSH_RECORD_SESSIONS="1"''}
+            ${backendExport}
+            ${secretsExport}
+            ${lib.optionalString (diskoConfig != null) ''export DISKO_CONFIG_PATH="${diskoConfig}"''}
+            ${lib.optionalString (deployer != null) ''export DEPLOYER="${deployer}"''}
+            ${lib.optionalString (deployUser != null) ''export DEPLOY_USER="${deployUser}"''}
+            # Pin the deploy-rs flake to the revision of the deploy binary above
+            export DEPLOY_RS_FLAKE="github:serokell/deploy-rs/${pkgs.deploy-rs.src.rev}"
+            ${lib.optionalString (nixOutputs != [ ]) ''export NIX_TF_OUTPUTS="${lib.concatStringsSep "," nixOutputs}"''}
+            ${lib.optionalString (snapshotRetention != null) ''export STATE_SNAPSHOT_RETENTION="${toString snapshotRetention}"''}
 
             # Run the inframan binary with all arguments
             exec ${inframanBin}/bin/inframan "$@"
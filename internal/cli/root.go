@@ -1,7 +1,13 @@
 package cli
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/iivel-inc/inframan/internal/commands"
+	"github.com/iivel-inc/inframan/internal/orchestrator"
 	"github.com/spf13/cobra"
 )
 
@@ -13,26 +19,82 @@ var rootCmd = &cobra.Command{
 and Colmena (NixOS Deployment).
 
 Environment Variables:
-  INFRA_CONFIG_JSON  - Path to the Terranix-generated JSON file
-  NIXOS_MODULE_PATH  - Path to the NixOS configuration module
-  PROJECT_NAME       - Project name for organizing .inframan/<project>/ folders (default: "default")
+  INFRA_CONFIG_JSON        - Path to the Terranix-generated JSON file
+  NIXOS_MODULE_PATH        - Path to the NixOS configuration module
+  PROJECT_NAME             - Project name for organizing .inframan/<project>/ folders (default: "default")
+  TF_BACKEND_JSON          - Managed Terraform backend configuration, injected into config.tf.json
+  SECRETS_JSON             - Secrets shipped to hosts as Colmena deployment keys (see 'inframan deploy --help')
+  DISKO_CONFIG_PATH        - Disk layout for 'inframan bootstrap'; deploy then only targets bootstrapped instances
+  DEPLOYER                 - Deployment backend: colmena (default), nixos-rebuild or deploy-rs
+  DEPLOY_USER              - User deployers log in as; other users than root act through sudo (default: "root")
+  DEPLOY_RS_FLAKE          - deploy-rs flake reference, pinned by the runner to its deploy binary
+  NIX_TF_OUTPUTS           - Comma-separated Terraform outputs passed to NixOS modules as inframan.outputs
+  STATE_SNAPSHOT_RETENTION - Number of state snapshots kept per project (default: 20)
+  SSH_CA_KEY_PATH          - SSH CA key for issuing short-lived certificates (see 'inframan cert')
+  SSH_RECORD_SESSIONS      - Record every 'inframan ssh' session for audit when set to "1"
+  INFRAMAN_LOCAL           - Target the project's local VMs (<project>-local) instead of the cloud when set to "1"
+  SSH_MULTIPLEX            - Share one SSH connection per host for a whole run (default: enabled, "0" disables)
 
 Commands:
-  infra   - Build and apply infrastructure using Terraform
-  deploy  - Deploy NixOS configuration using Colmena
-  destroy - Destroy infrastructure using Terraform
-  ssh     - SSH to an instance by project name`,
+  infra        - Build and apply infrastructure using Terraform
+  bootstrap    - Install NixOS on non-NixOS instances with nixos-anywhere
+  build        - Build every node's system closure once and write a manifest
+  deploy       - Deploy NixOS configuration with the project's deployer
+  rollback     - Switch NixOS hosts back to an earlier system generation
+  hardware     - Fetch hardware configurations from the project's hosts
+  vm           - Run the project's nodes as local QEMU VMs
+  destroy      - Destroy infrastructure using Terraform
+  drift        - Detect infrastructure changed outside of Terraform
+  history      - Show past infra, deploy and destroy runs
+  ssh          - SSH to an instance by project name
+  cert         - Manage short-lived SSH certificates from the project CA
+  recordings   - List and replay recorded SSH sessions
+  state        - Manage the project's Terraform state (migrate, snapshots, restore)
+  state-server - Run a Terraform HTTP state backend with versioning and locking`,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() error {
-	return rootCmd.Execute()
+	// An interrupt cancels the run's context; waits return early and commands unwind
+	// through their deferred cleanup (locks, history, terminal state)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	orchestrator.SetRunContext(ctx)
+
+	err := rootCmd.ExecuteContext(ctx)
+
+	// SSH ControlMasters are scoped to one run
+	orchestrator.StopSSHMultiplexing()
+
+	if ctx.Err() != nil {
+		return interruptedError{}
+	}
+	return err
 }
 
+// interruptedError makes an interrupted run exit with the shell's SIGINT status
+type interruptedError struct{}
+
+func (interruptedError) Error() string { return "interrupted" }
+
+// ExitCode returns 130 (128 + SIGINT)
+func (interruptedError) ExitCode() int { return 130 }
+
 func init() {
 	// Add subcommands
 	rootCmd.AddCommand(commands.NewInfraCommand())
+	rootCmd.AddCommand(commands.NewBootstrapCommand())
+	rootCmd.AddCommand(commands.NewBuildCommand())
 	rootCmd.AddCommand(commands.NewDeployCommand())
+	rootCmd.AddCommand(commands.NewRollbackCommand())
+	rootCmd.AddCommand(commands.NewHardwareCommand())
+	rootCmd.AddCommand(commands.NewVMCommand())
 	rootCmd.AddCommand(commands.NewDestroyCommand())
+	rootCmd.AddCommand(commands.NewDriftCommand())
+	rootCmd.AddCommand(commands.NewHistoryCommand())
 	rootCmd.AddCommand(commands.NewSSHCommand())
+	rootCmd.AddCommand(commands.NewCertCommand())
+	rootCmd.AddCommand(commands.NewRecordingsCommand())
+	rootCmd.AddCommand(commands.NewStateCommand())
+	rootCmd.AddCommand(commands.NewStateServerCommand())
 }
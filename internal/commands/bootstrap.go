@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewBootstrapCommand creates the bootstrap command
+func NewBootstrapCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var user string
+	var reinstall bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "bootstrap [instance...]",
+		Short: "Install NixOS on instances running another Linux",
+		Long: `Bootstrap installs NixOS from the project's machine module on freshly provisioned
+instances running any kexec-capable Linux, using nixos-anywhere. For each instance:
+1. Boots it into the NixOS installer with kexec (logging in as --user)
+2. Fetches its hardware configuration, without file systems, into
+   .inframan/<project>/hardware/<instance>.nix
+3. Builds the system and the disko partitioning script locally from the hive,
+   with the disko configuration from DISKO_CONFIG_PATH
+4. Partitions and formats the disks, installs the system and reboots
+5. Records the instance in .inframan/<project>/bootstrapped.json
+
+Once DISKO_CONFIG_PATH is set, 'inframan deploy' only targets bootstrapped
+instances. An instance replaced by Terraform (new address) needs bootstrapping again.
+
+Without arguments every instance not bootstrapped yet is installed. Installing
+erases the instance's disks, so bootstrap asks for confirmation unless --yes is given,
+and refuses to reinstall a bootstrapped instance unless --reinstall is given.`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			nixosModulePath := os.Getenv("NIXOS_MODULE_PATH")
+			if nixosModulePath == "" {
+				return fmt.Errorf("NIXOS_MODULE_PATH environment variable is not set")
+			}
+			if orchestrator.GetDiskoConfigPath() == "" {
+				return fmt.Errorf("no disk layout configured; set DISKO_CONFIG_PATH (mkRunner's diskoConfig parameter)")
+			}
+
+			projectName := orchestrator.GetProjectName()
+			instances, err := orchestrator.GetInstancesForProject(projectName)
+			if err != nil {
+				return fmt.Errorf("failed to get target instances: %w", err)
+			}
+			allNodes := orchestrator.HiveNodesFromInstances(instances)
+			_, pending, err := orchestrator.FilterBootstrapped(projectName, instances)
+			if err != nil {
+				return err
+			}
+			pendingNames := orchestrator.HiveNodeNames(orchestrator.HiveNodesFromInstances(pending))
+
+			// Pick the nodes to install: the named ones, or every pending one
+			var nodes []*orchestrator.HiveNode
+			for _, node := range allNodes {
+				if len(args) == 0 && containsString(pendingNames, node.Name) {
+					nodes = append(nodes, node)
+				}
+			}
+			for _, name := range args {
+				node := findHiveNode(allNodes, name)
+				if node == nil {
+					return fmt.Errorf("instance %q not found in project %q", name, projectName)
+				}
+				if !containsString(pendingNames, name) && !reinstall {
+					return fmt.Errorf("instance %q is already bootstrapped; use --reinstall to erase and install it again", name)
+				}
+				nodes = append(nodes, node)
+			}
+			if len(nodes) == 0 {
+				fmt.Println("Every instance is already bootstrapped.")
+				return nil
+			}
+			if err := orchestrator.AssignDiskoConfig(allNodes); err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			if !yes {
+				names := strings.Join(orchestrator.HiveNodeNames(nodes), ", ")
+				ok, err := confirm(fmt.Sprintf("Erase the disks of %s and install NixOS?", names))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					fmt.Println("Bootstrap cancelled.")
+					return nil
+				}
+			}
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			history := orchestrator.StartHistoryRecord("bootstrap")
+			history.ConfigHash, _ = orchestrator.HashFile(nixosModulePath)
+			history.Instances = orchestrator.HiveNodeNames(nodes)
+			history.Closures = make(map[string]string)
+			defer func() { finishHistory(history, err) }()
+
+			deployer, err := orchestrator.NewDeployer()
+			if err != nil {
+				return fmt.Errorf("failed to create deployer: %w", err)
+			}
+			topology, err := orchestrator.BuildTopology(projectName, allNodes)
+			if err != nil {
+				return err
+			}
+
+			for _, node := range nodes {
+				fmt.Printf("\n==> Bootstrapping %s (%s)\n", node.Name, node.Address)
+				systemPath, err := bootstrapNode(deployer, nixosModulePath, topology, node, user)
+				if err != nil {
+					return fmt.Errorf("failed to bootstrap %s: %w", node.Name, err)
+				}
+				history.Closures[node.Name] = systemPath
+
+				if err := orchestrator.RecordBootstrapped(projectName, node, systemPath); err != nil {
+					return err
+				}
+				if err := orchestrator.RecordDeployments(projectName, []*orchestrator.HiveNode{node}, history.Closures, false); err != nil {
+					return err
+				}
+				fmt.Printf("%s is running NixOS\n", node.Name)
+			}
+
+			fmt.Println("\nBootstrap completed successfully!")
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().StringVar(&user, "user", "root", "User to log in as on the instance's original Linux (needs sudo if not root)")
+	cmd.Flags().BoolVar(&reinstall, "reinstall", false, "Allow erasing and reinstalling instances that are already bootstrapped")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+
+	return cmd
+}
+
+// bootstrapNode installs NixOS on one node and returns the installed system closure
+func bootstrapNode(deployer orchestrator.Deployer, modulePath string, topology *orchestrator.Topology,
+	node *orchestrator.HiveNode, user string) (string, error) {
+	fmt.Println("Booting the NixOS installer...")
+	if err := orchestrator.KexecNode(node, user); err != nil {
+		return "", err
+	}
+	// The installer has its own host key
+	orchestrator.ForgetHostKey(node.Address)
+
+	fmt.Println("Fetching hardware configuration...")
+	if err := orchestrator.FetchHardwareConfig(node); err != nil {
+		return "", err
+	}
+
+	fmt.Println("Building system and disko script...")
+	if err := deployer.Generate(modulePath, []*orchestrator.HiveNode{node}, topology); err != nil {
+		return "", fmt.Errorf("failed to generate %s configuration: %w", deployer.Name(), err)
+	}
+	systemDrv, diskoDrv, err := orchestrator.EvalBootstrapDerivations(deployer, node.Name)
+	if err != nil {
+		return "", err
+	}
+	systemPath, err := orchestrator.RealiseDerivation(systemDrv)
+	if err != nil {
+		return "", err
+	}
+	diskoScript, err := orchestrator.RealiseDerivation(diskoDrv)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("Partitioning disks and installing...")
+	if err := orchestrator.InstallNode(node, diskoScript, systemPath); err != nil {
+		return "", err
+	}
+	// The installed system generates yet another host key
+	orchestrator.ForgetHostKey(node.Address)
+
+	return systemPath, nil
+}
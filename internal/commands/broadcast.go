@@ -0,0 +1,308 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+)
+
+// broadcastHost is one remote shell of a broadcast session
+type broadcastHost struct {
+	info    *orchestrator.InstanceInfo
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	pending []string      // input lines not yet written to stdin, guarded by the session's mu
+	closing bool          // no more input follows pending, guarded by the session's mu
+	wake    chan struct{} // signals the writer that pending or closing changed
+	enabled bool
+	exited  bool
+}
+
+// broadcastSession relays typed input to the shells of several hosts and
+// prints their output with a per-host prefix
+type broadcastSession struct {
+	mu       sync.Mutex
+	hosts    []*broadcastHost
+	nameLen  int
+	finished sync.WaitGroup
+}
+
+// runBroadcast opens a shell on every instance matched by the selector and
+// broadcasts each input line to all enabled hosts
+func runBroadcast(selector, user, identityFile string) error {
+	instances, err := orchestrator.SelectInstances(selector)
+	if err != nil {
+		return fmt.Errorf("failed to select instances: %w", err)
+	}
+
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		return fmt.Errorf("ssh not found in PATH: %w", err)
+	}
+
+	session := &broadcastSession{}
+	for _, info := range instances {
+		if len(info.FullName()) > session.nameLen {
+			session.nameLen = len(info.FullName())
+		}
+	}
+
+	for _, info := range instances {
+		if err := session.start(sshPath, info, user, identityFile); err != nil {
+			session.closeAll()
+			return err
+		}
+	}
+
+	fmt.Printf("Broadcasting to %d hosts as %s. Input lines are sent to every enabled host.\n", len(instances), user)
+	printBroadcastHelp()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			if quit := session.control(line); quit {
+				break
+			}
+			continue
+		}
+		if !session.send(line) {
+			fmt.Println("All sessions have exited.")
+			break
+		}
+	}
+
+	session.closeAll()
+	session.finished.Wait()
+	return nil
+}
+
+// start opens a shell on one host and relays its output
+func (s *broadcastSession) start(sshPath string, info *orchestrator.InstanceInfo, user, identityFile string) error {
+	sshArgs, err := buildSSHArgs(info, user, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// No remote tty: each host runs a plain shell reading lines from stdin
+	cmd := exec.Command(sshPath, append([]string{"-T"}, sshArgs...)...)
+	cmd.Env = os.Environ()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin for %s: %w", info.FullName(), err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdout for %s: %w", info.FullName(), err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stderr for %s: %w", info.FullName(), err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ssh to %s: %w", info.FullName(), err)
+	}
+
+	host := &broadcastHost{info: info, cmd: cmd, stdin: stdin, wake: make(chan struct{}, 1), enabled: true}
+	s.hosts = append(s.hosts, host)
+	go s.write(host)
+
+	var streams sync.WaitGroup
+	streams.Add(2)
+	go s.relay(host, stdout, &streams)
+	go s.relay(host, stderr, &streams)
+
+	s.finished.Add(1)
+	go func() {
+		defer s.finished.Done()
+		streams.Wait()
+		err := cmd.Wait()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		host.exited = true
+		if err != nil {
+			s.printLocked(host, fmt.Sprintf("session closed: %v", err))
+		} else {
+			s.printLocked(host, "session closed")
+		}
+	}()
+
+	return nil
+}
+
+// relay prints every output line of a host with its prefix
+func (s *broadcastSession) relay(host *broadcastHost, r io.Reader, streams *sync.WaitGroup) {
+	defer streams.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s.mu.Lock()
+		s.printLocked(host, scanner.Text())
+		s.mu.Unlock()
+	}
+}
+
+// printLocked prints a prefixed line; the caller must hold s.mu
+func (s *broadcastSession) printLocked(host *broadcastHost, line string) {
+	fmt.Printf("[%-*s] %s\n", s.nameLen, host.info.FullName(), line)
+}
+
+// write feeds a host's pending input lines to its shell without holding s.mu, so a host
+// that stops reading only blocks its own writer, and closes the shell's input when closing
+func (s *broadcastSession) write(host *broadcastHost) {
+	defer host.stdin.Close()
+	failed := false
+	for {
+		s.mu.Lock()
+		lines, closing := host.pending, host.closing
+		host.pending = nil
+		s.mu.Unlock()
+
+		for _, line := range lines {
+			if failed {
+				break
+			}
+			if _, err := io.WriteString(host.stdin, line+"\n"); err != nil {
+				failed = true
+				s.mu.Lock()
+				s.printLocked(host, fmt.Sprintf("failed to send input: %v", err))
+				s.mu.Unlock()
+			}
+		}
+		if closing {
+			return
+		}
+		<-host.wake
+	}
+}
+
+// wakeLocked signals a host's writer; the caller must hold s.mu
+func (host *broadcastHost) wakeLocked() {
+	select {
+	case host.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send queues a line for every enabled host, returning false once all hosts have exited
+func (s *broadcastSession) send(line string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alive := false
+	for _, host := range s.hosts {
+		if host.exited {
+			continue
+		}
+		alive = true
+		if !host.enabled {
+			continue
+		}
+		host.pending = append(host.pending, line)
+		host.wakeLocked()
+	}
+	return alive
+}
+
+// control handles a ":" command, returning true if the session should end
+func (s *broadcastSession) control(line string) bool {
+	fields := strings.Fields(strings.TrimPrefix(line, ":"))
+	if len(fields) == 0 {
+		printBroadcastHelp()
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	command, names := fields[0], fields[1:]
+	switch command {
+	case "quit", "q", "exit":
+		return true
+	case "hosts", "h":
+		for _, host := range s.hosts {
+			state := "on"
+			if host.exited {
+				state = "exited"
+			} else if !host.enabled {
+				state = "off"
+			}
+			fmt.Printf("  %-*s %-15s %s\n", s.nameLen, host.info.FullName(), host.info.PublicIP, state)
+		}
+	case "all":
+		for _, host := range s.hosts {
+			host.enabled = true
+		}
+		fmt.Println("Input goes to all hosts.")
+	case "on", "off", "only":
+		if len(names) == 0 {
+			fmt.Printf("Usage: :%s <host>...\n", command)
+			return false
+		}
+		matched := s.matchHostsLocked(names)
+		if len(matched) == 0 {
+			fmt.Printf("No host matches %s (see :hosts)\n", strings.Join(names, " "))
+			return false
+		}
+		if command == "only" {
+			for _, host := range s.hosts {
+				host.enabled = false
+			}
+		}
+		for _, host := range matched {
+			host.enabled = command != "off"
+		}
+		if command == "off" {
+			fmt.Printf("Input disabled for %d host(s).\n", len(matched))
+		} else {
+			fmt.Printf("Input enabled for %d host(s).\n", len(matched))
+		}
+	default:
+		fmt.Printf("Unknown command :%s\n", command)
+		printBroadcastHelp()
+	}
+	return false
+}
+
+// matchHostsLocked returns the hosts named by instance name or full name; the caller must hold s.mu
+func (s *broadcastSession) matchHostsLocked(names []string) []*broadcastHost {
+	var matched []*broadcastHost
+	for _, host := range s.hosts {
+		for _, name := range names {
+			if name == host.info.FullName() || name == host.info.InstanceName {
+				matched = append(matched, host)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// closeAll closes the input of every host once its queued lines are written, so their shells exit
+func (s *broadcastSession) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, host := range s.hosts {
+		host.closing = true
+		host.wakeLocked()
+	}
+}
+
+// printBroadcastHelp prints the control commands of a broadcast session
+func printBroadcastHelp() {
+	fmt.Println(`Commands:
+  :hosts             List hosts and whether they receive input
+  :off <host>...     Stop sending input to hosts
+  :on <host>...      Resume sending input to hosts
+  :only <host>...    Send input only to these hosts
+  :all               Send input to every host
+  :quit              Close all sessions (or press Ctrl-D)`)
+}
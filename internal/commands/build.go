@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewBuildCommand creates the build command
+func NewBuildCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var builder string
+	var output string
+	var skipSSHChecks bool
+	var fetchHardware bool
+
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build every node's system closure once and write a manifest for 'deploy --manifest'",
+		Long: `Build evaluates every node of the project with the project's deployer, builds
+all system closures into the local Nix store, and writes a manifest mapping each node
+to its closure (default: .inframan/<project>/manifest.json). Each closure is kept from
+garbage collection by a root in the gcroots/ directory next to the manifest.
+
+Unlike 'inframan deploy', which builds on every target, the closures are built once:
+locally, or with --builder on a Nix remote builder (any builders URI, such as
+ssh-ng://nix@builder.example.com), whose outputs are copied back. The SSH access
+checks of 'inframan deploy' run on the evaluated configurations; the manifest records
+whether they did.
+
+'inframan deploy --manifest <file>' then copies exactly these closures to the nodes
+and activates them. The closures carry the project's topology (instances, addresses,
+NIX_TF_OUTPUTS), which the manifest records, so a manifest only deploys to the project
+it was built for, as long as its topology has not changed.
+
+Examples:
+  # Build locally
+  inframan build
+
+  # Build on a remote builder and keep the manifest with the release
+  inframan build --builder ssh-ng://nix@builder.example.com --output release.json
+
+  # Deploy exactly those closures
+  nix run .#staging -- deploy --manifest release.json`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Get NIXOS_MODULE_PATH from environment
+			nixosModulePath := os.Getenv("NIXOS_MODULE_PATH")
+			if nixosModulePath == "" {
+				return fmt.Errorf("NIXOS_MODULE_PATH environment variable is not set")
+			}
+
+			// Verify the module file exists
+			if _, err := os.Stat(nixosModulePath); os.IsNotExist(err) {
+				return fmt.Errorf("NIXOS_MODULE_PATH file does not exist: %s", nixosModulePath)
+			}
+
+			if output == "" {
+				if output, err = orchestrator.GetManifestPath(); err != nil {
+					return err
+				}
+			}
+
+			cmd.SilenceUsage = true
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			history := orchestrator.StartHistoryRecord("build")
+			history.ConfigHash, _ = orchestrator.HashFile(nixosModulePath)
+			defer func() { finishHistory(history, err) }()
+
+			projectName := orchestrator.GetProjectName()
+			_, nodes, err := loadDeployNodes(projectName, fetchHardware)
+			if err != nil {
+				return err
+			}
+			history.Instances = orchestrator.HiveNodeNames(nodes)
+
+			deployer, err := orchestrator.NewDeployer()
+			if err != nil {
+				return fmt.Errorf("failed to create deployer: %w", err)
+			}
+			fmt.Printf("Generating %s configuration...\n", deployer.Name())
+			topology, err := orchestrator.BuildTopology(projectName, nodes)
+			if err != nil {
+				return err
+			}
+			if err := deployer.Generate(nixosModulePath, nodes, topology); err != nil {
+				return fmt.Errorf("failed to generate %s configuration: %w", deployer.Name(), err)
+			}
+
+			// Refuse to build closures that would cut off our own SSH access once deployed
+			if !skipSSHChecks {
+				fmt.Println("Checking SSH access after activation...")
+				if err := checkSSHAccess(deployer, nodes); err != nil {
+					return err
+				}
+			}
+
+			fmt.Println("Evaluating system closures...")
+			drvs, err := orchestrator.EvalSystemDerivations(deployer)
+			if err != nil {
+				return err
+			}
+			if builder != "" {
+				fmt.Printf("Building %d system closure(s) on %s...\n", len(drvs), builder)
+			} else {
+				fmt.Printf("Building %d system closure(s) locally...\n", len(drvs))
+			}
+			closures, err := orchestrator.BuildSystems(drvs, builder, orchestrator.GetManifestGCRootsDir(output))
+			if err != nil {
+				return err
+			}
+			history.Closures = closures
+
+			manifest := &orchestrator.Manifest{
+				Project:     projectName,
+				BuiltAt:     history.Timestamp,
+				Operator:    history.Operator,
+				GitRevision: history.GitRevision,
+				ConfigHash:  history.ConfigHash,
+				Builder:     builder,
+				SSHChecked:  !skipSSHChecks,
+				Topology:    topology,
+				Nodes:       closures,
+			}
+			if err := manifest.Save(output); err != nil {
+				return err
+			}
+
+			names := make([]string, 0, len(closures))
+			for name := range closures {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("  %-20s %s\n", name, closures[name])
+			}
+			fmt.Printf("Manifest written to %s\n", output)
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().StringVar(&builder, "builder", "", "Nix remote builder to build on (e.g. ssh-ng://nix@builder); default: build locally")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Manifest file to write (default: .inframan/<project>/manifest.json)")
+	cmd.Flags().BoolVar(&fetchHardware, "fetch-hardware", false, "Fetch the hardware configuration of instances that have none yet (see 'inframan hardware')")
+	cmd.Flags().BoolVar(&skipSSHChecks, "skip-ssh-checks", false, "Build even if a node's configuration would block inframan's SSH login")
+
+	return cmd
+}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewCertCommand creates the cert command
+func NewCertCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Manage short-lived SSH certificates from the project CA",
+		Long: `Cert manages short-lived SSH user certificates signed by the project CA.
+
+When SSH_CA_KEY_PATH is set, 'ssh' and 'deploy' authenticate with a certificate
+issued on demand instead of SSH_KEY_PATH. Certificates are cached under
+.inframan/<project>/ssh/ and reissued shortly before they expire.
+
+Environment Variables:
+  SSH_CA_KEY_PATH      - Path to the CA private key used to sign certificates
+  SSH_CERT_PRINCIPALS  - Comma-separated principals to embed (default: "root")
+  SSH_CERT_TTL         - Certificate lifetime, e.g. "30m" or "8h" (default: "1h")
+
+Hosts must trust the CA. 'inframan deploy' configures that on the nodes it deploys;
+'inframan cert trusted-ca' prints a NixOS module for other hosts.`,
+	}
+
+	cmd.AddCommand(newCertIssueCommand())
+	cmd.AddCommand(newCertShowCommand())
+	cmd.AddCommand(newCertTrustedCACommand())
+
+	return cmd
+}
+
+// newCertIssueCommand creates the cert issue subcommand
+func newCertIssueCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "issue",
+		Short: "Issue a new certificate, replacing any cached one",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cert, err := orchestrator.IssueSSHCertificate()
+			if err != nil {
+				return fmt.Errorf("failed to issue certificate: %w", err)
+			}
+			printCertificate(cert)
+			return nil
+		},
+	}
+}
+
+// newCertShowCommand creates the cert show subcommand
+func newCertShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show",
+		Short: "Show the cached certificate",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cert, err := orchestrator.LoadSSHCertificate()
+			if err != nil {
+				return err
+			}
+			if cert == nil {
+				fmt.Println("No certificate issued yet.")
+				fmt.Println("Run 'inframan cert issue' or any SSH-using command to issue one.")
+				return nil
+			}
+			printCertificate(cert)
+			return nil
+		},
+	}
+}
+
+// newCertTrustedCACommand creates the cert trusted-ca subcommand
+func newCertTrustedCACommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "trusted-ca",
+		Short: "Print a NixOS module that trusts the project CA",
+		Long: `Trusted-ca prints a NixOS module configuring sshd's TrustedUserCAKeys
+with the project CA public key. Import it from your machine module:
+
+  inframan cert trusted-ca > ssh-ca.nix`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snippet, err := orchestrator.TrustedUserCAKeysSnippet()
+			if err != nil {
+				return err
+			}
+			fmt.Print(snippet)
+			return nil
+		},
+	}
+}
+
+// printCertificate displays certificate details
+func printCertificate(cert *orchestrator.SSHCertificate) {
+	status := "valid"
+	if !cert.Valid() {
+		status = "expired"
+	}
+
+	fmt.Printf("Certificate: %s\n", cert.CertPath)
+	fmt.Printf("Identity:    %s\n", cert.Identity)
+	fmt.Printf("Principals:  %s\n", strings.Join(cert.Principals, ", "))
+	fmt.Printf("Issued:      %s\n", cert.IssuedAt.Format(time.RFC3339))
+	fmt.Printf("Expires:     %s (%s)\n", cert.ValidBefore.Format(time.RFC3339), status)
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+)
+
+// showClosureDiffs builds the nodes' new systems on their hosts and prints, per node, the
+// packages and closure size that change compared with the running system. It returns the
+// number of nodes whose system would change.
+func showClosureDiffs(deployer orchestrator.Deployer, nodes []*orchestrator.HiveNode) (int, error) {
+	fmt.Printf("Building with %s...\n", deployer.Name())
+	if err := deployer.Apply("build", orchestrator.HiveNodeNames(nodes)); err != nil {
+		return 0, err
+	}
+	systemPaths, err := orchestrator.EvalSystemPaths(deployer)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, node := range nodes {
+		diff, err := orchestrator.DiffNodeClosure(node.Address, systemPaths[node.Name])
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", node.Name, err)
+		}
+		printClosureDiff(node, diff)
+		if !diff.Unchanged() {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// printClosureDiff prints one node's package changes ([U]pdated, [A]dded, [R]emoved)
+// and closure size change
+func printClosureDiff(node *orchestrator.HiveNode, diff *orchestrator.ClosureDiff) {
+	fmt.Printf("\n%s (%s):\n", node.Name, node.Address)
+	if diff.Unchanged() {
+		fmt.Printf("  no changes, already running %s\n", diff.Current.Path)
+		return
+	}
+
+	width := 0
+	for _, changes := range [][]orchestrator.PackageChange{diff.Changed, diff.Added, diff.Removed} {
+		for _, change := range changes {
+			if len(change.Name) > width {
+				width = len(change.Name)
+			}
+		}
+	}
+	for _, change := range diff.Changed {
+		fmt.Printf("  [U] %-*s %s -> %s\n", width, change.Name, formatVersions(change.OldVersions), formatVersions(change.NewVersions))
+	}
+	for _, change := range diff.Added {
+		fmt.Printf("  [A] %-*s %s\n", width, change.Name, formatVersions(change.NewVersions))
+	}
+	for _, change := range diff.Removed {
+		fmt.Printf("  [R] %-*s %s\n", width, change.Name, formatVersions(change.OldVersions))
+	}
+	if len(diff.Changed)+len(diff.Added)+len(diff.Removed) == 0 {
+		fmt.Println("  no package version changes (configuration only)")
+	}
+
+	oldSize, newSize := diff.Current.Size(), diff.New.Size()
+	delta := orchestrator.FormatBytes(newSize - oldSize)
+	if newSize >= oldSize {
+		delta = "+" + delta
+	}
+	fmt.Printf("  Closure: %d -> %d paths, %s -> %s (%s)\n",
+		len(diff.Current.Sizes), len(diff.New.Sizes), orchestrator.FormatBytes(oldSize), orchestrator.FormatBytes(newSize), delta)
+}
+
+// formatVersions joins a package's versions, leaving out the empty version of unversioned paths
+func formatVersions(versions []string) string {
+	var shown []string
+	for _, version := range versions {
+		if version != "" {
+			shown = append(shown, version)
+		}
+	}
+	if len(shown) == 0 {
+		return "-"
+	}
+	return strings.Join(shown, ", ")
+}
@@ -3,71 +3,443 @@ package commands
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/iivel-inc/inframan/internal/term"
 	"github.com/spf13/cobra"
 )
 
 // NewDeployCommand creates the deploy command
 func NewDeployCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var rollout rolloutOptions
+	var keysOnly bool
+	var skipSSHChecks bool
+	var fetchHardware bool
+	var yes bool
+	var diffOnly bool
+	var manifestPath string
+
 	cmd := &cobra.Command{
 		Use:   "deploy",
-		Short: "Deploy NixOS configuration using Colmena",
+		Short: "Deploy NixOS configuration with the project's deployer",
 		Long: `Deploy orchestrates NixOS deployment:
 1. Fetches infrastructure state from Terraform
-2. Parses target IP from terraform output
-3. Generates ephemeral hive.nix with injected IP
-4. Runs colmena apply to deploy to the target`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Get NIXOS_MODULE_PATH from environment
+2. Parses target IPs from terraform output (instances map or public_ip)
+3. Generates the deployer's node definitions (DEPLOYER: colmena hive.nix by default,
+   or nodes.nix for nixos-rebuild and deploy-rs) with one node per instance, passing the project
+   topology (instances, addresses, NIX_TF_OUTPUTS) to modules as the inframan argument
+   and importing hardware configurations fetched with 'inframan hardware'
+   (or --fetch-hardware for instances that have none yet)
+4. Evaluates each node and refuses to deploy a configuration that would lock inframan out
+5. Runs the deployer (colmena apply, nixos-rebuild --target-host or deploy-rs) on the targets
+6. Records each node's system closure in .inframan/<project>/deployments.json
+   (compare hosts against it with 'inframan drift --hosts')
+
+Activation goals:
+  --goal picks what happens once the systems are built, as in nixos-rebuild:
+  switch (default) activates them and makes them the boot default, boot only makes
+  them the boot default (--reboot then reboots the nodes one at a time, waiting for
+  each to come back), test activates them without changing the boot default, build
+  only builds them, and dry-activate builds them and reports, per node, the units
+  switching would stop, restart, reload or start, without changing anything.
+
+Manifests:
+  --manifest deploys the closures listed in a manifest written by 'inframan build'
+  instead of evaluating and building: each node's closure is copied from the local
+  store and activated, and secrets are installed over SSH. The closures carry the
+  project and topology they were built with, so a manifest is only deployed to the
+  project it was built for and is refused if the project's topology has changed
+  since; a manifest built with --skip-ssh-checks is refused unless deploy is given
+  --skip-ssh-checks too.
+
+Closure diffs:
+  Before activating, the new systems are built on their hosts and, per node, the
+  packages added, removed or changed in version (like nvd) and the closure size
+  change against /run/current-system are shown; deploy then asks for confirmation.
+  --yes deploys without showing the diffs or asking, for non-interactive runs such
+  as CI; without it, deploy fails at once when stdin is not a terminal.
+  --diff-only shows the changes and stops.
+
+Rolling deploys:
+  With --batch-size, nodes are deployed a few at a time (a count or a percentage).
+  After each batch the --health-* checks must pass on every node of the batch
+  within --health-timeout; otherwise the rollout stops, and with
+  --rollback-failed-batch the batch is switched back to its previous generation.
+
+Secrets:
+  Secrets declared in SECRETS_JSON (mkRunner's secrets parameter) are shipped as
+  Colmena deployment keys, or installed over SSH by the other deployers. Values come from local files, environment variables,
+  or age/sops-encrypted files and are read locally at upload time, never stored in
+  the hive or the Nix store. --keys-only rotates them without a system switch.
+
+SSH access checks:
+  The public half of SSH_KEY_PATH is added to the deploy user's authorized keys on
+  every node, and sshd trusts the project CA when SSH_CA_KEY_PATH is set. Before
+  anything is built, each node's configuration is evaluated and the deploy is
+  refused if sshd is disabled or not listening on port 22, the firewall closes
+  port 22, root logins are forbidden, or the deploy user has no way to authenticate
+  (no authorized keys, or no trusted CA or matching certificate principal when
+  SSH_CA_KEY_PATH is set). --skip-ssh-checks deploys anyway.
+
+Confirm or revert:
+  With --confirm, each node arms an on-host systemd timer before activation that
+  switches it back to its current generation. After activation inframan must open
+  a fresh SSH connection (not the shared ControlMaster) within --confirm-timeout to
+  disarm it; a node that locked inframan out reverts on its own.
+
+Examples:
+  # Deploy everything at once, after reviewing the package changes
+  inframan deploy
+
+  # Deploy from CI without a prompt
+  inframan deploy --yes
+
+  # Two web servers at a time, each must serve /health within 2 minutes
+  inframan deploy --batch-size 2 --health-http 'http://{address}/health'
+
+  # A quarter of the fleet at a time, undoing a batch whose nginx fails to start
+  inframan deploy --batch-size 25% --health-unit nginx.service --rollback-failed-batch
+
+  # Preview which services a switch would restart
+  inframan deploy --goal dry-activate
+
+  # Only show the package changes
+  inframan deploy --diff-only
+
+  # Install a new kernel and reboot into it, one node at a time
+  inframan deploy --goal boot --reboot --health-unit nginx.service
+
+  # Deploy exactly the closures built by 'inframan build'
+  inframan deploy --manifest release.json
+
+  # Rotate secrets only
+  inframan deploy --keys-only
+
+  # Guard against sshd or firewall changes that lock us out
+  inframan deploy --confirm --confirm-timeout 5m`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Get NIXOS_MODULE_PATH from environment; a manifest was built from it already
 			nixosModulePath := os.Getenv("NIXOS_MODULE_PATH")
-			if nixosModulePath == "" {
-				return fmt.Errorf("NIXOS_MODULE_PATH environment variable is not set")
+			if manifestPath == "" {
+				if nixosModulePath == "" {
+					return fmt.Errorf("NIXOS_MODULE_PATH environment variable is not set")
+				}
+
+				// Verify the module file exists
+				if _, err := os.Stat(nixosModulePath); os.IsNotExist(err) {
+					return fmt.Errorf("NIXOS_MODULE_PATH file does not exist: %s", nixosModulePath)
+				}
 			}
 
-			// Verify the module file exists
-			if _, err := os.Stat(nixosModulePath); os.IsNotExist(err) {
-				return fmt.Errorf("NIXOS_MODULE_PATH file does not exist: %s", nixosModulePath)
+			if err := rollout.validate(); err != nil {
+				return err
+			}
+			// A manifest is activated by inframan itself, which supports every goal
+			if manifestPath == "" {
+				if err := orchestrator.ValidateDeployerGoal(orchestrator.GetDeployerName(), rollout.goal); err != nil {
+					return err
+				}
+			}
+			if keysOnly && (cmd.Flags().Changed("batch-size") || rollout.confirm || cmd.Flags().Changed("goal") || diffOnly || manifestPath != "") {
+				return fmt.Errorf("--keys-only does not switch systems and cannot be combined with --batch-size, --confirm, --goal, --diff-only or --manifest")
+			}
+			// Deploy asks before activating; without a terminal to answer on, fail before any work
+			if !keysOnly && !diffOnly && !yes && orchestrator.GoalChangesNodes(rollout.goal) && !term.IsTerminal(int(os.Stdin.Fd())) {
+				return fmt.Errorf("deploy asks for confirmation but stdin is not a terminal; use --yes to deploy without confirmation")
+			}
+			if manifestPath != "" && fetchHardware {
+				return fmt.Errorf("--fetch-hardware cannot change a manifest's prebuilt closures; fetch before 'inframan build'")
 			}
 
-			// Create terraform executor to get output
-			terraformExec, err := orchestrator.NewTerraformExecutor()
-			if err != nil {
-				return fmt.Errorf("failed to create terraform executor: %w", err)
+			// A manifest only deploys to the project it was built for, whose topology its closures carry
+			projectName := orchestrator.GetProjectName()
+			var manifest *orchestrator.Manifest
+			if manifestPath != "" {
+				if manifest, err = orchestrator.LoadManifest(manifestPath); err != nil {
+					return err
+				}
+				if manifest.Project != projectName {
+					return fmt.Errorf("manifest %s was built for project %q; build a manifest for %q instead", manifestPath, manifest.Project, projectName)
+				}
+				if !manifest.SSHChecked && !skipSSHChecks {
+					return fmt.Errorf("manifest %s was built with --skip-ssh-checks; rebuild it with the checks or deploy with --skip-ssh-checks", manifestPath)
+				}
 			}
 
-			// Get target IP from terraform output
-			fmt.Println("Fetching infrastructure state...")
-			targetIP, err := terraformExec.GetTargetIP()
+			// Validate secrets up front, so a missing file fails before anything is deployed
+			secrets, err := orchestrator.LoadSecrets()
 			if err != nil {
-				return fmt.Errorf("failed to get target IP: %w", err)
+				return err
 			}
-			fmt.Printf("Target IP: %s\n", targetIP)
+			if keysOnly && len(secrets) == 0 {
+				return fmt.Errorf("--keys-only needs secrets; none are configured (SECRETS_JSON)")
+			}
+
+			// From here on a failure is about the deployment, not the invocation
+			cmd.SilenceUsage = true
 
-			// Create colmena executor
-			colmenaExec, err := orchestrator.NewColmenaExecutor()
+			// Serialize runs that touch this project's .inframan directory
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
 			if err != nil {
-				return fmt.Errorf("failed to create colmena executor: %w", err)
+				return err
+			}
+			defer lock.Release()
+
+			// Record the run in the project's history, whatever its outcome
+			historyCommand := "deploy"
+			if keysOnly {
+				historyCommand = "upload-keys"
+			}
+			history := orchestrator.StartHistoryRecord(historyCommand)
+			if manifest != nil {
+				history.ConfigHash = manifest.ConfigHash
+			} else {
+				history.ConfigHash, _ = orchestrator.HashFile(nixosModulePath)
 			}
+			defer func() { finishHistory(history, err) }()
 
-			// Generate dynamic hive.nix
-			fmt.Println("Generating Colmena hive configuration...")
-			hivePath, err := colmenaExec.GenerateHive(nixosModulePath, targetIP)
+			instances, nodes, err := loadDeployNodes(projectName, fetchHardware)
 			if err != nil {
-				return fmt.Errorf("failed to generate hive: %w", err)
+				return err
 			}
-			fmt.Printf("Generated hive at: %s\n", hivePath)
+			if err := orchestrator.AssignSecrets(nodes, secrets); err != nil {
+				return err
+			}
+			history.Instances = orchestrator.HiveNodeNames(nodes)
+
+			var deployer orchestrator.Deployer
+			if manifest != nil {
+				// Deploy exactly the manifest's closures
+				// The closures carry the topology they were built with; it must still be this project's
+				topology, err := orchestrator.BuildTopology(projectName, nodes)
+				if err != nil {
+					return err
+				}
+				deployer = orchestrator.NewManifestDeployer(manifest)
+				if err := deployer.Generate(nixosModulePath, nodes, topology); err != nil {
+					return err
+				}
+			} else {
+				// Create the project's deployer (DEPLOYER)
+				if deployer, err = orchestrator.NewDeployer(); err != nil {
+					return fmt.Errorf("failed to create deployer: %w", err)
+				}
 
-			// Run colmena apply
-			fmt.Println("Deploying with Colmena...")
-			if err := colmenaExec.Apply(hivePath); err != nil {
-				return fmt.Errorf("colmena apply failed: %w", err)
+				// Generate the node definitions
+				fmt.Printf("Generating %s configuration...\n", deployer.Name())
+				topology, err := orchestrator.BuildTopology(projectName, nodes)
+				if err != nil {
+					return err
+				}
+				if err := deployer.Generate(nixosModulePath, nodes, topology); err != nil {
+					return fmt.Errorf("failed to generate %s configuration: %w", deployer.Name(), err)
+				}
 			}
 
+			// Rotate secrets without building or switching the system
+			if keysOnly {
+				var keyNodes []string
+				for _, node := range nodes {
+					if len(node.Secrets) > 0 {
+						keyNodes = append(keyNodes, node.Name)
+					}
+				}
+				history.Instances = keyNodes
+				fmt.Printf("Uploading %d secret(s) to %s...\n", len(secrets), strings.Join(keyNodes, ", "))
+				if err := deployer.UploadKeys(keyNodes); err != nil {
+					return err
+				}
+				fmt.Println("Secrets uploaded successfully!")
+				return nil
+			}
+
+			// Refuse configurations that would cut off our own SSH access
+			if manifest != nil && !skipSSHChecks {
+				// A manifest has no configuration left to evaluate; 'inframan build' checked it
+				fmt.Println("SSH access was checked when the manifest was built")
+			} else if !skipSSHChecks {
+				fmt.Println("Checking SSH access after activation...")
+				if err := checkSSHAccess(deployer, nodes); err != nil {
+					return err
+				}
+			}
+
+			// Show what changes on each node and ask before anything is activated, unless --yes
+			if diffOnly || (!yes && orchestrator.GoalChangesNodes(rollout.goal)) {
+				changed, err := showClosureDiffs(deployer, nodes)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("\n%d of %d node(s) would change.\n", changed, len(nodes))
+				if diffOnly {
+					return nil
+				}
+				ok, err := confirm(fmt.Sprintf("Deploy with --goal %s?", rollout.goal))
+				if err != nil {
+					return fmt.Errorf("%w (use --yes to deploy without confirmation)", err)
+				}
+				if !ok {
+					fmt.Println("Deployment cancelled.")
+					return nil
+				}
+			}
+
+			// Build (and dry-activate) without changing what the nodes run
+			if !orchestrator.GoalChangesNodes(rollout.goal) {
+				return previewDeploy(deployer, nodes, rollout.goal)
+			}
+
+			// Deploy in one go or batch by batch
+			if err := runRollout(deployer, projectName, instances, &rollout, history); err != nil {
+				return err
+			}
+
+			if rollout.goal == "boot" && !rollout.reboot {
+				fmt.Println("Deployment completed successfully! Nodes run the new system after their next reboot.")
+				return nil
+			}
 			fmt.Println("Deployment completed successfully!")
 			return nil
 		},
 	}
 
+	addLockFlags(cmd, &lockTimeout)
+	addRolloutFlags(cmd, &rollout)
+	cmd.Flags().BoolVar(&keysOnly, "keys-only", false, "Only upload secrets (deployment keys), without building or switching the system")
+	cmd.Flags().BoolVar(&fetchHardware, "fetch-hardware", false, "Fetch the hardware configuration of instances that have none yet (see 'inframan hardware')")
+	cmd.Flags().StringVar(&manifestPath, "manifest", "", "Deploy the prebuilt closures of a manifest written by 'inframan build' instead of building")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Deploy without showing the package changes or asking for confirmation")
+	cmd.Flags().BoolVar(&diffOnly, "diff-only", false, "Build and show each node's package and closure size changes without deploying")
+	cmd.Flags().BoolVar(&skipSSHChecks, "skip-ssh-checks", false, "Deploy even if a node's configuration would block inframan's SSH login")
+
 	return cmd
 }
+
+// loadDeployNodes fetches the project's instances and returns those running NixOS with
+// their hive nodes, importing stored hardware configurations (fetching missing ones if asked to)
+func loadDeployNodes(projectName string, fetchHardware bool) ([]*orchestrator.InstanceInfo, []*orchestrator.HiveNode, error) {
+	// Get target IPs from terraform output
+	fmt.Println("Fetching infrastructure state...")
+	instances, err := orchestrator.GetInstancesForProject(projectName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get target instances: %w", err)
+	}
+
+	// With a disk layout configured, only instances installed by bootstrap run NixOS
+	instances, pending, err := orchestrator.FilterBootstrapped(projectName, instances)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range orchestrator.HiveNodesFromInstances(pending) {
+		fmt.Printf("Skipping %s: not bootstrapped yet (run 'inframan bootstrap')\n", node.Name)
+	}
+	if len(instances) == 0 {
+		return nil, nil, fmt.Errorf("no bootstrapped instances to deploy to; run 'inframan bootstrap' first")
+	}
+	nodes := orchestrator.HiveNodesFromInstances(instances)
+	if err := orchestrator.AssignDiskoConfig(nodes); err != nil {
+		return nil, nil, err
+	}
+	for _, node := range nodes {
+		fmt.Printf("Target %s: %s\n", node.Name, node.Address)
+	}
+
+	// Import stored hardware configurations, fetching missing ones if asked to
+	if err := orchestrator.AssignHardwareConfigs(nodes); err != nil {
+		return nil, nil, err
+	}
+	if missing := missingHardwareConfigs(nodes); fetchHardware && len(missing) > 0 {
+		fmt.Println("Fetching hardware configurations...")
+		if err := fetchHardwareConfigs(missing); err != nil {
+			return nil, nil, err
+		}
+	}
+	return instances, nodes, nil
+}
+
+// checkSSHAccess evaluates the nodes and fails if any of them would no longer
+// accept inframan's SSH login once the new configuration is active
+func checkSSHAccess(deployer orchestrator.Deployer, nodes []*orchestrator.HiveNode) error {
+	access, err := orchestrator.EvalSSHAccess(deployer, nodes)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate SSH configuration (use --skip-ssh-checks to deploy anyway): %w", err)
+	}
+
+	var certPrincipals []string
+	if orchestrator.GetSSHCAKeyPath() != "" {
+		certPrincipals = orchestrator.GetSSHCertPrincipals()
+	}
+	var blocked []string
+	for _, node := range nodes {
+		nodeAccess, ok := access[node.Name]
+		if !ok {
+			return fmt.Errorf("SSH configuration of %s missing from evaluation", node.Name)
+		}
+		for _, problem := range nodeAccess.Problems(certPrincipals) {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", node.Name, problem)
+			if !containsString(blocked, node.Name) {
+				blocked = append(blocked, node.Name)
+			}
+		}
+	}
+
+	if len(blocked) > 0 {
+		return fmt.Errorf("refusing to deploy: %s would lock inframan out (use --skip-ssh-checks to deploy anyway)", strings.Join(blocked, ", "))
+	}
+	return nil
+}
+
+// previewDeploy builds the nodes' systems on their hosts without activating them. With
+// dry-activate it then reports the units switching to them would stop, restart, reload or start.
+func previewDeploy(deployer orchestrator.Deployer, nodes []*orchestrator.HiveNode, goal string) error {
+	fmt.Printf("Building with %s...\n", deployer.Name())
+	if err := deployer.Apply("build", orchestrator.HiveNodeNames(nodes)); err != nil {
+		return err
+	}
+	if goal == "build" {
+		fmt.Println("Build completed; nothing was activated.")
+		return nil
+	}
+
+	systemPaths, err := orchestrator.EvalSystemPaths(deployer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nDry activation (nothing was changed):")
+	var failed []string
+	for _, node := range nodes {
+		result, err := orchestrator.DryActivate(node.Address, systemPaths[node.Name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", node.Name, err)
+			failed = append(failed, node.Name)
+			continue
+		}
+		if result.Empty() {
+			fmt.Printf("  %s: no units would change\n", node.Name)
+			continue
+		}
+		fmt.Printf("  %s:\n", node.Name)
+		for _, change := range []struct {
+			action string
+			units  []string
+		}{
+			{"stop", result.Stop},
+			{"restart", result.Restart},
+			{"reload", result.Reload},
+			{"start", result.Start},
+		} {
+			if len(change.units) > 0 {
+				fmt.Printf("    would %-7s %s\n", change.action, strings.Join(change.units, ", "))
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("dry activation failed on %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package commands
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/iivel-inc/inframan/internal/orchestrator"
 	"github.com/spf13/cobra"
@@ -9,6 +11,9 @@ import (
 
 // NewDestroyCommand creates the destroy command
 func NewDestroyCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var noSnapshot bool
+
 	cmd := &cobra.Command{
 		Use:   "destroy",
 		Short: "Destroy infrastructure using Terraform",
@@ -19,7 +24,22 @@ func NewDestroyCommand() *cobra.Command {
 
 This is the reverse of 'inframan infra' and will destroy all resources
 that were created during infrastructure provisioning.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if orchestrator.LocalVMsEnabled() {
+				return fmt.Errorf("local VMs are not provisioned by Terraform; use 'inframan vm start' and 'inframan vm stop'")
+			}
+
+			// Serialize runs that touch this project's .inframan directory
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			// Record the run in the project's history, whatever its outcome
+			history := orchestrator.StartHistoryRecord("destroy")
+			defer func() { finishHistory(history, err) }()
+
 			// Create terraform executor
 			terraformExec, err := orchestrator.NewTerraformExecutor()
 			if err != nil {
@@ -31,6 +51,19 @@ that were created during infrastructure provisioning.`,
 				return fmt.Errorf("failed to initialize terraform: %w", err)
 			}
 
+			// Record what is about to be destroyed
+			history.ConfigHash, _ = orchestrator.HashFile(filepath.Join(terraformExec.GetWorkDir(), orchestrator.ConfigFileName))
+			if instances, err := orchestrator.GetInstancesForProject(orchestrator.GetProjectName()); err == nil {
+				history.Instances = instanceNodeNames(instances)
+			}
+
+			// Snapshot the current state so the change can be undone with 'state restore'
+			if !noSnapshot {
+				if err := takeStateSnapshot(terraformExec, "destroy"); err != nil {
+					return err
+				}
+			}
+
 			// Run terraform destroy
 			fmt.Println("Destroying infrastructure...")
 			if err := terraformExec.Destroy(); err != nil {
@@ -42,5 +75,8 @@ that were created during infrastructure provisioning.`,
 		},
 	}
 
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVar(&noSnapshot, "no-snapshot", false, "Skip the state snapshot taken before changing infrastructure")
+
 	return cmd
 }
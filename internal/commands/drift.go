@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewDriftCommand creates the drift command
+func NewDriftCommand() *cobra.Command {
+	var hosts bool
+
+	cmd := &cobra.Command{
+		Use:   "drift [project]",
+		Short: "Detect infrastructure changed outside of Terraform",
+		Long: `Drift runs a refresh-only Terraform plan for one project, or for every project
+in .inframan/ when none is given, and lists the resources whose real-world state
+no longer matches Terraform state. State is not modified.
+
+With --hosts, the NixOS side is checked instead: every instance's /run/current-system
+and system profile are compared with the closure recorded by the last 'inframan deploy',
+reporting hosts that run something else (e.g. a manual nixos-rebuild) or whose
+profile and running system disagree (mid-rollback or unfinished activation).
+Hosts deployed with --goal boot that have not rebooted yet are reported as
+pending reboot, not as drift.
+
+Exit codes, for use in scheduled jobs:
+  0 - no drift
+  1 - a project could not be checked
+  2 - drift detected
+
+Examples:
+  # Check every project
+  inframan drift
+
+  # Check a single project
+  inframan drift production
+
+  # Check that every production host still runs the deployed system
+  inframan drift --hosts production`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var projects []string
+			if len(args) == 1 {
+				projects = args
+			} else {
+				all, err := orchestrator.GetAllProjectDirs()
+				if err != nil {
+					return fmt.Errorf("failed to list projects: %w", err)
+				}
+				if len(all) == 0 {
+					fmt.Println("No projects found.")
+					return nil
+				}
+				projects = all
+			}
+
+			// From here on a failure is about the infrastructure, not the invocation
+			cmd.SilenceUsage = true
+
+			if hosts {
+				return runHostDrift(projects)
+			}
+
+			var reports []*orchestrator.DriftReport
+			var failed []string
+			for _, project := range projects {
+				// Local VMs are not managed by Terraform; only their hosts can drift
+				if len(args) == 0 && orchestrator.IsLocalProject(project) {
+					continue
+				}
+				fmt.Printf("Checking %s for drift...\n", project)
+				report, err := orchestrator.DetectDrift(project)
+				if err != nil {
+					fmt.Printf("  error: %v\n", err)
+					failed = append(failed, project)
+					continue
+				}
+				printDriftReport(report)
+				reports = append(reports, report)
+			}
+
+			var drifted []string
+			fmt.Println("\nSummary:")
+			for _, report := range reports {
+				if report.Drifted() {
+					drifted = append(drifted, report.ProjectName)
+					fmt.Printf("  %-20s %d drifted resource(s)\n", report.ProjectName, len(report.Resources))
+				} else {
+					fmt.Printf("  %-20s no drift\n", report.ProjectName)
+				}
+			}
+			for _, project := range failed {
+				fmt.Printf("  %-20s check failed\n", project)
+			}
+
+			if len(failed) > 0 {
+				return fmt.Errorf("drift check failed for: %s", strings.Join(failed, ", "))
+			}
+			if len(drifted) > 0 {
+				return &exitCodeError{code: 2, msg: fmt.Sprintf("drift detected in: %s", strings.Join(drifted, ", "))}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&hosts, "hosts", false, "Compare running NixOS systems with the last deployment instead of Terraform state")
+
+	return cmd
+}
+
+// runHostDrift checks the hosts of every project against their last deployment
+func runHostDrift(projects []string) error {
+	var drifted, failed []string
+	for _, project := range projects {
+		fmt.Printf("Checking %s hosts...\n", project)
+		results, err := orchestrator.CheckHostDrift(project)
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			failed = append(failed, project)
+			continue
+		}
+
+		for _, result := range results {
+			fmt.Printf("  %-20s %s\n", result.Node, result.Status)
+			switch {
+			case result.Err != nil:
+				fmt.Printf("    %v\n", result.Err)
+				failed = append(failed, project+"/"+result.Node)
+			case result.Drifted():
+				fmt.Printf("    deployed: %s\n", result.Deployed)
+				fmt.Printf("    running:  %s\n", result.Current)
+				if result.Profile != result.Current {
+					fmt.Printf("    profile:  %s\n", result.Profile)
+				}
+				drifted = append(drifted, project+"/"+result.Node)
+			case result.Status == orchestrator.HostPendingReboot:
+				fmt.Printf("    running:   %s\n", result.Current)
+				fmt.Printf("    next boot: %s\n", result.Deployed)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("host check failed for: %s", strings.Join(failed, ", "))
+	}
+	if len(drifted) > 0 {
+		return &exitCodeError{code: 2, msg: fmt.Sprintf("host drift detected on: %s", strings.Join(drifted, ", "))}
+	}
+	return nil
+}
+
+// printDriftReport displays the drifted resources of a project
+func printDriftReport(report *orchestrator.DriftReport) {
+	if !report.Drifted() {
+		fmt.Println("  No drift.")
+		return
+	}
+	for _, resource := range report.Resources {
+		switch {
+		case containsString(resource.Actions, "delete"):
+			fmt.Printf("  - %s (deleted outside of Terraform)\n", resource.Address)
+		case len(resource.Attributes) > 0:
+			fmt.Printf("  ~ %s (%s)\n", resource.Address, strings.Join(resource.Attributes, ", "))
+		default:
+			fmt.Printf("  ~ %s\n", resource.Address)
+		}
+	}
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
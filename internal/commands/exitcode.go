@@ -0,0 +1,17 @@
+package commands
+
+// exitCodeError is an error that asks the process to exit with a specific code,
+// for commands whose result (not just failure) is meant to be read by scripts
+type exitCodeError struct {
+	code int
+	msg  string
+}
+
+func (e *exitCodeError) Error() string {
+	return e.msg
+}
+
+// ExitCode returns the process exit code for the error
+func (e *exitCodeError) ExitCode() int {
+	return e.code
+}
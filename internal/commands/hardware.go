@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewHardwareCommand creates the hardware command
+func NewHardwareCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var refresh bool
+
+	cmd := &cobra.Command{
+		Use:   "hardware [instance...]",
+		Short: "Fetch hardware configurations from the project's hosts",
+		Long: `Hardware runs 'nixos-generate-config --show-hardware-config' on the project's
+instances and stores the result in .inframan/<project>/hardware/<instance>.nix.
+Deploy imports each stored file into its node's hive entry, next to the machine
+module, so the module no longer needs instance-type specific boot and disk settings.
+
+Without arguments all instances of the project are fetched. Instances that already
+have a hardware configuration are skipped unless --refresh is given.
+
+'inframan deploy --fetch-hardware' fetches missing configurations as part of a deploy.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			projectName := orchestrator.GetProjectName()
+			instances, err := orchestrator.GetInstancesForProject(projectName)
+			if err != nil {
+				return fmt.Errorf("failed to get target instances: %w", err)
+			}
+			nodes := orchestrator.HiveNodesFromInstances(instances)
+
+			if len(args) > 0 {
+				var selected []*orchestrator.HiveNode
+				for _, name := range args {
+					node := findHiveNode(nodes, name)
+					if node == nil {
+						return fmt.Errorf("instance %q not found in project %q", name, projectName)
+					}
+					selected = append(selected, node)
+				}
+				nodes = selected
+			}
+
+			cmd.SilenceUsage = true
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			if err := orchestrator.AssignHardwareConfigs(nodes); err != nil {
+				return err
+			}
+			if err := orchestrator.AssignDiskoConfig(nodes); err != nil {
+				return err
+			}
+			if !refresh {
+				nodes = missingHardwareConfigs(nodes)
+			}
+			if len(nodes) == 0 {
+				fmt.Println("Every instance already has a hardware configuration (use --refresh to fetch again).")
+				return nil
+			}
+
+			return fetchHardwareConfigs(nodes)
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Fetch again for instances that already have a hardware configuration")
+
+	return cmd
+}
+
+// findHiveNode returns the node with the given name, or nil
+func findHiveNode(nodes []*orchestrator.HiveNode, name string) *orchestrator.HiveNode {
+	for _, node := range nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// missingHardwareConfigs returns the nodes without a stored hardware configuration
+func missingHardwareConfigs(nodes []*orchestrator.HiveNode) []*orchestrator.HiveNode {
+	var missing []*orchestrator.HiveNode
+	for _, node := range nodes {
+		if node.HardwareConfig == "" {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
+
+// fetchHardwareConfigs fetches the hardware configuration of every node in parallel
+func fetchHardwareConfigs(nodes []*orchestrator.HiveNode) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *orchestrator.HiveNode) {
+			defer wg.Done()
+			errs[i] = orchestrator.FetchHardwareConfig(node)
+		}(i, node)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, node := range nodes {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", node.Name, errs[i])
+			failed++
+			continue
+		}
+		fmt.Printf("  %s: %s\n", node.Name, node.HardwareConfig)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to fetch %d of %d hardware configurations", failed, len(nodes))
+	}
+	return nil
+}
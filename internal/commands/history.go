@@ -0,0 +1,157 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewHistoryCommand creates the history command
+func NewHistoryCommand() *cobra.Command {
+	var filter orchestrator.HistoryFilter
+	var since string
+	var limit int
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "history [project]",
+		Short: "Show past infra, deploy and destroy runs",
+		Long: `History lists the runs recorded in .inframan/<project>/history.jsonl, newest first.
+
+Every infra, deploy and destroy appends a record with the time, operator, git
+revision of the working tree, config hash, targeted instances, deployed system
+closures, duration and outcome. Without a project argument, PROJECT_NAME is used.
+
+Examples:
+  # Last 20 runs of the current project
+  inframan history
+
+  # Failed deploys in production over the last week
+  inframan history production --command deploy --outcome failure --since 168h
+
+  # Every run that touched web-1, as JSON lines
+  inframan history production --instance web-1 --limit 0 --json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			projectName := orchestrator.GetProjectName()
+			if len(args) == 1 {
+				projectName = args[0]
+			}
+
+			if since != "" {
+				sinceTime, err := parseSince(since)
+				if err != nil {
+					return err
+				}
+				filter.Since = sinceTime
+			}
+
+			records, err := orchestrator.ReadHistory(projectName)
+			if err != nil {
+				return err
+			}
+
+			// Newest first, filtered and limited
+			var selected []*orchestrator.HistoryRecord
+			for i := len(records) - 1; i >= 0; i-- {
+				if !filter.Matches(records[i]) {
+					continue
+				}
+				selected = append(selected, records[i])
+				if limit > 0 && len(selected) == limit {
+					break
+				}
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				for _, record := range selected {
+					if err := encoder.Encode(record); err != nil {
+						return fmt.Errorf("failed to encode history record: %w", err)
+					}
+				}
+				return nil
+			}
+
+			if len(selected) == 0 {
+				fmt.Printf("No history found for project %q.\n", projectName)
+				return nil
+			}
+
+			fmt.Printf("%-20s %-11s %-12s %-8s %-9s %-13s %s\n", "TIME", "COMMAND", "OPERATOR", "OUTCOME", "DURATION", "REVISION", "INSTANCES")
+			for _, record := range selected {
+				fmt.Printf("%-20s %-11s %-12s %-8s %-9s %-13s %s\n",
+					record.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					record.Command,
+					record.Operator,
+					record.Outcome,
+					(time.Duration(record.Duration * float64(time.Second))).Round(time.Second),
+					shortRevision(record.GitRevision),
+					strings.Join(record.Instances, ","))
+				if record.Pending {
+					fmt.Println("  pending: deployed closures take effect at the next boot")
+				}
+				if record.Error != "" {
+					fmt.Printf("  error: %s\n", record.Error)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&filter.Command, "command", "", "Only show runs of this command (infra, deploy or destroy)")
+	cmd.Flags().StringVar(&filter.Operator, "operator", "", "Only show runs by this operator")
+	cmd.Flags().StringVar(&filter.Outcome, "outcome", "", "Only show runs with this outcome (success or failure)")
+	cmd.Flags().StringVar(&filter.Instance, "instance", "", "Only show runs that targeted this instance")
+	cmd.Flags().StringVar(&since, "since", "", "Only show runs newer than a duration (e.g. 24h) or date (2006-01-02)")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "Maximum number of runs to show (0 shows all)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the records as JSON lines")
+
+	return cmd
+}
+
+// parseSince parses a --since value as a duration before now or as a date
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a duration such as 24h or a date such as 2006-01-02", value)
+}
+
+// shortRevision abbreviates a git revision for display, keeping a "-dirty" suffix
+func shortRevision(revision string) string {
+	if revision == "" {
+		return "-"
+	}
+	hash, dirty := strings.CutSuffix(revision, "-dirty")
+	if len(hash) > 7 {
+		hash = hash[:7]
+	}
+	if dirty {
+		return hash + "-dirty"
+	}
+	return hash
+}
+
+// finishHistory completes the history record of a run; failing to write it only warns,
+// since the run itself has already happened
+func finishHistory(record *orchestrator.HistoryRecord, runErr error) {
+	if err := record.Finish(runErr); err != nil {
+		fmt.Printf("Warning: failed to record history: %v\n", err)
+	}
+}
+
+// instanceNodeNames returns the node names of a project's instances for history records
+func instanceNodeNames(instances []*orchestrator.InstanceInfo) []string {
+	return orchestrator.HiveNodeNames(orchestrator.HiveNodesFromInstances(instances))
+}
@@ -3,6 +3,8 @@ package commands
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/iivel-inc/inframan/internal/orchestrator"
 	"github.com/spf13/cobra"
@@ -10,6 +12,9 @@ import (
 
 // NewInfraCommand creates the infra command
 func NewInfraCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var noSnapshot bool
+
 	cmd := &cobra.Command{
 		Use:   "infra",
 		Short: "Apply infrastructure using Terranix and Terraform",
@@ -18,7 +23,11 @@ func NewInfraCommand() *cobra.Command {
 2. Copies config to .inframan/terraform/config.tf.json
 3. Runs terraform init and terraform apply
 4. Passes through AWS credentials from environment`,
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if orchestrator.LocalVMsEnabled() {
+				return fmt.Errorf("local VMs are not provisioned by Terraform; use 'inframan vm start' and 'inframan vm stop'")
+			}
+
 			// Get INFRA_CONFIG_JSON from environment
 			infraConfigJSON := os.Getenv("INFRA_CONFIG_JSON")
 			if infraConfigJSON == "" {
@@ -30,6 +39,17 @@ func NewInfraCommand() *cobra.Command {
 				return fmt.Errorf("INFRA_CONFIG_JSON file does not exist: %s", infraConfigJSON)
 			}
 
+			// Serialize runs that touch this project's .inframan directory
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			// Record the run in the project's history, whatever its outcome
+			history := orchestrator.StartHistoryRecord("infra")
+			defer func() { finishHistory(history, err) }()
+
 			// Create terranix executor to copy config
 			terranixExec, err := orchestrator.NewTerranixExecutor()
 			if err != nil {
@@ -48,22 +68,39 @@ func NewInfraCommand() *cobra.Command {
 				return fmt.Errorf("failed to create terraform executor: %w", err)
 			}
 
+			history.ConfigHash, _ = orchestrator.HashFile(filepath.Join(terraformExec.GetWorkDir(), orchestrator.ConfigFileName))
+
 			// Run terraform init
 			fmt.Println("Initializing Terraform...")
 			if err := terraformExec.Init(); err != nil {
 				return fmt.Errorf("terraform init failed: %w", err)
 			}
 
+			// Snapshot the current state so the change can be undone with 'state restore'
+			if !noSnapshot {
+				if err := takeStateSnapshot(terraformExec, "apply"); err != nil {
+					return err
+				}
+			}
+
 			// Run terraform apply
 			fmt.Println("Applying infrastructure...")
 			if err := terraformExec.Apply(); err != nil {
 				return fmt.Errorf("terraform apply failed: %w", err)
 			}
 
+			// Best effort: a project without instance outputs is still a successful apply
+			if instances, err := orchestrator.GetInstancesForProject(orchestrator.GetProjectName()); err == nil {
+				history.Instances = instanceNodeNames(instances)
+			}
+
 			fmt.Println("Infrastructure applied successfully!")
 			return nil
 		},
 	}
 
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVar(&noSnapshot, "no-snapshot", false, "Skip the state snapshot taken before changing infrastructure")
+
 	return cmd
 }
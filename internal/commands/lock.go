@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// addLockFlags adds the flags controlling how a command waits for the project lock
+func addLockFlags(cmd *cobra.Command, lockTimeout *time.Duration) {
+	cmd.Flags().DurationVar(lockTimeout, "lock-timeout", 0,
+		"How long to wait for another inframan run on this project to finish (0 fails immediately)")
+}
+
+// acquireProjectLock takes the project lock on behalf of a command
+func acquireProjectLock(cmd *cobra.Command, args []string, lockTimeout time.Duration) (*orchestrator.ProjectLock, error) {
+	command := strings.TrimSpace(cmd.CommandPath() + " " + strings.Join(args, " "))
+	lock, err := orchestrator.AcquireProjectLock(command, lockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock project %q: %w", orchestrator.GetProjectName(), err)
+	}
+	return lock, nil
+}
@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/iivel-inc/inframan/internal/term"
+)
+
+const (
+	// pickerMaxRows is the number of matches shown at once
+	pickerMaxRows = 10
+
+	keyCtrlC     = 3
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEnter     = 13
+	keyEscape    = 27
+	keyBackspace = 127
+	keyCtrlH     = 8
+)
+
+// errPickerCancelled is returned when the user leaves the picker without choosing
+var errPickerCancelled = fmt.Errorf("no instance selected")
+
+// pickerItem is one selectable instance
+type pickerItem struct {
+	info  *orchestrator.InstanceInfo
+	label string
+}
+
+// pickInstance shows an interactive fuzzy finder over the instances and
+// returns the one the user selects
+func pickInstance(instances []*orchestrator.InstanceInfo) (*orchestrator.InstanceInfo, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	items := make([]pickerItem, len(instances))
+	for i, inst := range instances {
+		items[i] = pickerItem{info: inst, label: fmt.Sprintf("%-30s %s", inst.FullName(), inst.PublicIP)}
+	}
+
+	query := ""
+	cursor := 0
+	out := os.Stderr
+	rendered := 0
+
+	buf := make([]byte, 16)
+	for {
+		matches := filterPickerItems(items, query)
+		if cursor >= len(matches) {
+			cursor = len(matches) - 1
+		}
+		if cursor < 0 {
+			cursor = 0
+		}
+		rendered = renderPicker(out, rendered, query, matches, len(items), cursor)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			clearPicker(out, rendered)
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		input := buf[:n]
+		switch {
+		case n == 1 && input[0] == keyEnter:
+			clearPicker(out, rendered)
+			if len(matches) == 0 {
+				return nil, errPickerCancelled
+			}
+			return matches[cursor].info, nil
+		case n == 1 && (input[0] == keyCtrlC || input[0] == keyEscape):
+			clearPicker(out, rendered)
+			return nil, errPickerCancelled
+		case n == 1 && (input[0] == keyBackspace || input[0] == keyCtrlH):
+			if runes := []rune(query); len(runes) > 0 {
+				query = string(runes[:len(runes)-1])
+			}
+		case n == 1 && input[0] == keyCtrlU:
+			query = ""
+		case (n == 1 && input[0] == keyCtrlP) || string(input) == "\x1b[A":
+			cursor--
+		case (n == 1 && input[0] == keyCtrlN) || string(input) == "\x1b[B":
+			cursor++
+		default:
+			for _, r := range string(input) {
+				if unicode.IsPrint(r) {
+					query += string(r)
+					cursor = 0
+				}
+			}
+		}
+	}
+}
+
+// renderPicker redraws the prompt and match list, returning the number of lines drawn
+func renderPicker(out *os.File, previous int, query string, matches []pickerItem, total, cursor int) int {
+	var b strings.Builder
+
+	// Move back to the first line of the previous render and clear below it
+	b.WriteString("\r")
+	if previous > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", previous-1)
+	}
+	b.WriteString("\x1b[J")
+
+	// Scroll the window so the cursor stays visible
+	start := 0
+	if cursor >= pickerMaxRows {
+		start = cursor - pickerMaxRows + 1
+	}
+	end := start + pickerMaxRows
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	lines := 1
+	for i := start; i < end; i++ {
+		if i == cursor {
+			fmt.Fprintf(&b, "\x1b[7m> %s\x1b[0m\r\n", matches[i].label)
+		} else {
+			fmt.Fprintf(&b, "  %s\r\n", matches[i].label)
+		}
+		lines++
+	}
+	if len(matches) == 0 {
+		b.WriteString("  (no matching instances)\r\n")
+		lines++
+	}
+
+	// The prompt goes last so the terminal cursor ends up on it
+	fmt.Fprintf(&b, "Select instance (%d/%d)> %s", len(matches), total, query)
+	out.WriteString(b.String())
+	return lines
+}
+
+// clearPicker erases the picker from the terminal
+func clearPicker(out *os.File, rendered int) {
+	var b strings.Builder
+	b.WriteString("\r")
+	if rendered > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", rendered-1)
+	}
+	b.WriteString("\x1b[J")
+	out.WriteString(b.String())
+}
+
+// filterPickerItems returns the items that fuzzy-match the query, best matches first
+func filterPickerItems(items []pickerItem, query string) []pickerItem {
+	type scored struct {
+		item  pickerItem
+		score int
+	}
+
+	var results []scored
+	for _, item := range items {
+		if score, ok := fuzzyScore(query, item.label); ok {
+			results = append(results, scored{item: item, score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score < results[j].score
+	})
+
+	matches := make([]pickerItem, len(results))
+	for i, r := range results {
+		matches[i] = r.item
+	}
+	return matches
+}
+
+// fuzzyScore reports whether every character of query appears in candidate in order
+// (case-insensitive) and scores the match; lower is better. Matches that start
+// early and have few gaps between characters score best.
+func fuzzyScore(query, candidate string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	q := []rune(strings.ToLower(query))
+	c := []rune(strings.ToLower(candidate))
+
+	score := 0
+	qi := 0
+	last := -1
+	for ci := 0; ci < len(c) && qi < len(q); ci++ {
+		if c[ci] != q[qi] {
+			continue
+		}
+		if last < 0 {
+			score += ci
+		} else {
+			score += ci - last - 1
+		}
+		last = ci
+		qi++
+	}
+
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
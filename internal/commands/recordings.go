@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewRecordingsCommand creates the recordings command
+func NewRecordingsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recordings",
+		Short: "List and replay recorded SSH sessions",
+		Long: `Recordings manages SSH sessions recorded with 'inframan ssh --record'
+(or with SSH_RECORD_SESSIONS=1).
+
+Sessions are stored as asciicast v2 files under .inframan/<project>/recordings/,
+with the remote user, local operator, target and start/end timestamps in the
+header. They can also be played with any asciicast player.`,
+	}
+
+	cmd.AddCommand(newRecordingsListCommand())
+	cmd.AddCommand(newRecordingsPlayCommand())
+
+	return cmd
+}
+
+// newRecordingsListCommand creates the recordings list subcommand
+func newRecordingsListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list [project]",
+		Short: "List recorded sessions",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			projects := args
+			if len(projects) == 0 {
+				var err error
+				projects, err = orchestrator.GetAllProjectDirs()
+				if err != nil {
+					return fmt.Errorf("failed to list projects: %w", err)
+				}
+			}
+
+			found := false
+			for _, project := range projects {
+				recordings, err := orchestrator.ListRecordings(project)
+				if err != nil {
+					return err
+				}
+				for _, rec := range recordings {
+					if !found {
+						fmt.Printf("%-45s %-20s %-25s %-10s %s\n", "RECORDING", "STARTED", "TARGET", "USER", "DURATION")
+						found = true
+					}
+					started := time.Unix(rec.Header.Timestamp, 0).Format("2006-01-02 15:04:05")
+					duration := "-"
+					if rec.Header.Duration > 0 {
+						duration = time.Duration(rec.Header.Duration * float64(time.Second)).Round(time.Second).String()
+					}
+					fmt.Printf("%-45s %-20s %-25s %-10s %s\n", rec.Name(), started, rec.Header.Target,
+						fmt.Sprintf("%s@%s", rec.Header.Operator, rec.Header.User), duration)
+				}
+			}
+
+			if !found {
+				fmt.Println("No recordings found.")
+			}
+			return nil
+		},
+	}
+}
+
+// newRecordingsPlayCommand creates the recordings play subcommand
+func newRecordingsPlayCommand() *cobra.Command {
+	var speed float64
+	var idleLimit time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "play <recording>",
+		Short: "Replay a recorded session in the terminal",
+		Long: `Play replays a recorded session with its original timing.
+
+The recording can be given as a path or as a name from 'inframan recordings list'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if speed <= 0 {
+				return fmt.Errorf("--speed must be positive")
+			}
+
+			path, err := findRecording(args[0])
+			if err != nil {
+				return err
+			}
+
+			header, events, err := orchestrator.ReadRecording(path)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Replaying %s (%s)\n", header.Title, time.Unix(header.Timestamp, 0).Format(time.RFC3339))
+
+			var last float64
+			for _, event := range events {
+				delay := time.Duration((event.Time - last) / speed * float64(time.Second))
+				if idleLimit > 0 && delay > idleLimit {
+					delay = idleLimit
+				}
+				if err := orchestrator.Sleep(delay); err != nil {
+					fmt.Println()
+					return err
+				}
+				last = event.Time
+				os.Stdout.WriteString(event.Data)
+			}
+
+			fmt.Println()
+			fmt.Println("End of recording.")
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64Var(&speed, "speed", 1, "Playback speed multiplier")
+	cmd.Flags().DurationVar(&idleLimit, "idle-limit", 2*time.Second, "Maximum pause between events (0 keeps original pauses)")
+
+	return cmd
+}
+
+// findRecording resolves a recording path or name across all projects
+func findRecording(nameOrPath string) (string, error) {
+	if _, err := os.Stat(nameOrPath); err == nil {
+		return nameOrPath, nil
+	}
+
+	projects, err := orchestrator.GetAllProjectDirs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list projects: %w", err)
+	}
+	for _, project := range projects {
+		recordings, err := orchestrator.ListRecordings(project)
+		if err != nil {
+			return "", err
+		}
+		for _, rec := range recordings {
+			if rec.Name() == nameOrPath {
+				return rec.Path, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("recording %q not found", nameOrPath)
+}
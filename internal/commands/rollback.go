@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewRollbackCommand creates the rollback command
+func NewRollbackCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var generation int
+	var closure string
+	var previousDeploy bool
+
+	cmd := &cobra.Command{
+		Use:   "rollback <selector>",
+		Short: "Switch NixOS hosts back to an earlier system generation",
+		Long: `Rollback switches the hosts matched by a selector (project, project/instance or
+project/glob terms, comma-separated) back to an earlier system and activates it, on
+all hosts in parallel. It reports each node's generation before and after.
+
+By default each node goes back to the generation before its current one
+(like 'nixos-rebuild switch --rollback'). Alternatively:
+  --generation N      switch to system generation N
+  --closure PATH      set a specific system closure (one instance only)
+  --previous-deploy   set the closure each node ran before its last deploy,
+                      taken from 'inframan history'
+
+Successful rollbacks update the deployment record used by 'drift --hosts' and are
+added to each project's history.
+
+Examples:
+  # Undo the last switch on every production host
+  inframan rollback production
+
+  # Put web-1 back on generation 41
+  inframan rollback production/web-1 --generation 41
+
+  # Return the web hosts to what was deployed before the last deploy
+  inframan rollback 'production/web-*' --previous-deploy`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flagsSet := 0
+			for _, set := range []bool{generation != 0, closure != "", previousDeploy} {
+				if set {
+					flagsSet++
+				}
+			}
+			if flagsSet > 1 {
+				return fmt.Errorf("--generation, --closure and --previous-deploy are mutually exclusive")
+			}
+
+			instances, err := orchestrator.SelectInstances(args[0])
+			if err != nil {
+				return err
+			}
+			if closure != "" && len(instances) != 1 {
+				return fmt.Errorf("--closure needs a selector matching exactly one instance, %q matches %d", args[0], len(instances))
+			}
+
+			// From here on a failure is about the hosts, not the invocation
+			cmd.SilenceUsage = true
+
+			// Resolve each node's target up front so nothing is switched if one cannot be
+			targets := make([]orchestrator.RollbackTarget, len(instances))
+			nodes := orchestrator.HiveNodesFromInstances(instances)
+			for i, inst := range instances {
+				target := orchestrator.RollbackTarget{Generation: generation, Closure: closure}
+				if previousDeploy {
+					if target.Closure, err = orchestrator.PreviousDeployedClosure(inst.ProjectName, nodes[i].Name); err != nil {
+						return err
+					}
+				}
+				if err := target.Validate(); err != nil {
+					return err
+				}
+				targets[i] = target
+			}
+
+			// Lock every project involved, in a fixed order so concurrent rollbacks cannot deadlock
+			projects := instanceProjects(instances)
+			command := strings.TrimSpace(cmd.CommandPath() + " " + strings.Join(args, " "))
+			for _, project := range projects {
+				lock, err := orchestrator.AcquireLockForProject(project, command, lockTimeout)
+				if err != nil {
+					return fmt.Errorf("failed to lock project %q: %w", project, err)
+				}
+				defer lock.Release()
+			}
+
+			histories := make(map[string]*orchestrator.HistoryRecord)
+			for _, project := range projects {
+				histories[project] = orchestrator.StartHistoryRecordForProject(project, "rollback")
+			}
+
+			fmt.Printf("Rolling back %d instance(s)...\n", len(instances))
+			results := orchestrator.RollbackInstances(instances, targets)
+
+			fmt.Printf("\n%-28s %-8s %-8s %s\n", "INSTANCE", "BEFORE", "AFTER", "STATUS")
+			var failed []string
+			for i, result := range results {
+				status := "switched to " + targets[i].String()
+				if result.Err != nil {
+					status = "failed: " + result.Err.Error()
+					failed = append(failed, result.Instance.FullName())
+				}
+				fmt.Printf("%-28s %-8s %-8s %s\n", result.Instance.FullName(),
+					generationNumber(result.Before), generationNumber(result.After), status)
+			}
+
+			recordRollback(projects, histories, results)
+
+			if len(failed) > 0 {
+				return fmt.Errorf("rollback failed on: %s", strings.Join(failed, ", "))
+			}
+			fmt.Println("\nRollback completed successfully!")
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().IntVar(&generation, "generation", 0, "Switch to this system generation instead of the previous one")
+	cmd.Flags().StringVar(&closure, "closure", "", "Switch to this system closure (store path)")
+	cmd.Flags().BoolVar(&previousDeploy, "previous-deploy", false, "Switch each node to the closure it ran before its last deploy")
+
+	return cmd
+}
+
+// recordRollback updates the deployment records and history of every project after a rollback
+func recordRollback(projects []string, histories map[string]*orchestrator.HistoryRecord, results []*orchestrator.RollbackResult) {
+	for _, project := range projects {
+		history := histories[project]
+		var nodes []*orchestrator.HiveNode
+		systemPaths := make(map[string]string)
+		var failed []string
+
+		for _, result := range results {
+			if result.Instance.ProjectName != project {
+				continue
+			}
+			history.Instances = append(history.Instances, result.Node)
+			if result.Err != nil {
+				failed = append(failed, result.Node)
+				continue
+			}
+			nodes = append(nodes, &orchestrator.HiveNode{Name: result.Node, Address: result.Instance.PublicIP})
+			systemPaths[result.Node] = result.After.SystemPath
+		}
+
+		if len(nodes) > 0 {
+			if err := orchestrator.RecordDeployments(project, nodes, systemPaths, false); err != nil {
+				fmt.Printf("Warning: failed to record rolled back closures for %s: %v\n", project, err)
+			}
+			history.Closures = systemPaths
+		}
+
+		var err error
+		if len(failed) > 0 {
+			err = fmt.Errorf("rollback failed on: %s", strings.Join(failed, ", "))
+		}
+		finishHistory(history, err)
+	}
+}
+
+// instanceProjects returns the sorted, distinct projects of the instances
+func instanceProjects(instances []*orchestrator.InstanceInfo) []string {
+	seen := make(map[string]bool)
+	var projects []string
+	for _, inst := range instances {
+		if !seen[inst.ProjectName] {
+			seen[inst.ProjectName] = true
+			projects = append(projects, inst.ProjectName)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+// generationNumber formats a generation number for display
+func generationNumber(g *orchestrator.SystemGeneration) string {
+	if g == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", g.Number)
+}
@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// rolloutOptions controls how deploy pushes a configuration to the nodes of a project
+type rolloutOptions struct {
+	goal           string
+	reboot         bool
+	rebootTimeout  time.Duration
+	batchSize      string
+	health         orchestrator.HealthCheck
+	rollbackFailed bool
+	confirm        bool
+	confirmTimeout time.Duration
+}
+
+// addRolloutFlags adds the rolling deploy and health check flags
+func addRolloutFlags(cmd *cobra.Command, opts *rolloutOptions) {
+	cmd.Flags().StringVar(&opts.goal, "goal", orchestrator.DefaultActivationGoal, "Activation goal: "+strings.Join(orchestrator.ActivationGoals, ", "))
+	cmd.Flags().BoolVar(&opts.reboot, "reboot", false, "With --goal boot, reboot the nodes one at a time and wait for each to come back")
+	cmd.Flags().DurationVar(&opts.rebootTimeout, "reboot-timeout", orchestrator.DefaultRebootTimeout, "How long a node may take to come back after a reboot")
+	cmd.Flags().StringVar(&opts.batchSize, "batch-size", "", "Deploy this many nodes (e.g. 2) or this share of nodes (e.g. 25%) at a time (default: all at once)")
+	cmd.Flags().StringVar(&opts.health.HTTP, "health-http", "", "URL that must return 2xx after each batch; {address} and {node} are replaced per node")
+	cmd.Flags().IntVar(&opts.health.TCPPort, "health-tcp", 0, "TCP port that must accept connections after each batch")
+	cmd.Flags().StringVar(&opts.health.Unit, "health-unit", "", "systemd unit that must be active after each batch")
+	cmd.Flags().StringVar(&opts.health.Command, "health-command", "", "Local command that must succeed for each node (INFRAMAN_NODE and INFRAMAN_ADDRESS are set)")
+	cmd.Flags().DurationVar(&opts.health.Timeout, "health-timeout", orchestrator.DefaultHealthTimeout, "How long a node may take to pass its health checks")
+	cmd.Flags().BoolVar(&opts.rollbackFailed, "rollback-failed-batch", false, "Switch the nodes of a failed batch back to the generation they ran before it")
+	cmd.Flags().BoolVar(&opts.confirm, "confirm", false, "Revert nodes on their own unless a new SSH login succeeds after activation")
+	cmd.Flags().DurationVar(&opts.confirmTimeout, "confirm-timeout", orchestrator.DefaultConfirmTimeout, "How long after activation a node has to accept a new SSH login with --confirm")
+}
+
+// validate checks the rollout options before anything is deployed
+func (o *rolloutOptions) validate() error {
+	if err := orchestrator.ValidateActivationGoal(o.goal); err != nil {
+		return err
+	}
+	if o.reboot && o.goal != "boot" {
+		return fmt.Errorf("--reboot only applies to --goal boot")
+	}
+	if !orchestrator.GoalChangesNodes(o.goal) && (o.batchSize != "" || o.health.Enabled() || o.rollbackFailed || o.confirm) {
+		return fmt.Errorf("--goal %s does not change the nodes and cannot be combined with --batch-size, --health-*, --rollback-failed-batch or --confirm", o.goal)
+	}
+	if o.confirm && o.goal != "switch" && o.goal != "test" {
+		return fmt.Errorf("--confirm guards activation and needs --goal switch or test")
+	}
+	if o.goal == "boot" && !o.reboot && o.health.Enabled() {
+		return fmt.Errorf("health checks with --goal boot need --reboot; nodes run the new system only after rebooting")
+	}
+	if err := o.health.Validate(); err != nil {
+		return err
+	}
+	if _, err := orchestrator.ParseBatchSize(o.batchSize, 1); err != nil {
+		return err
+	}
+	if o.confirm {
+		return orchestrator.ValidateConfirmTimeout(o.confirmTimeout)
+	}
+	return nil
+}
+
+// runRollout deploys the nodes batch by batch, checking health after each batch and
+// stopping at the first failure. Closures of successful batches are recorded.
+func runRollout(deployer orchestrator.Deployer, projectName string,
+	instances []*orchestrator.InstanceInfo, opts *rolloutOptions, history *orchestrator.HistoryRecord) error {
+	nodes := orchestrator.HiveNodesFromInstances(instances)
+	size, err := orchestrator.ParseBatchSize(opts.batchSize, len(nodes))
+	if err != nil {
+		return err
+	}
+	batches := (len(nodes) + size - 1) / size
+
+	var systemPaths map[string]string
+	for batch := 0; batch < batches; batch++ {
+		if orchestrator.Interrupted() {
+			return orchestrator.ErrInterrupted
+		}
+		start, end := batch*size, (batch+1)*size
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		batchNodes := nodes[start:end]
+		names := orchestrator.HiveNodeNames(batchNodes)
+
+		if batches > 1 {
+			fmt.Printf("Deploying batch %d/%d: %s\n", batch+1, batches, strings.Join(names, ", "))
+		} else {
+			fmt.Printf("Deploying with %s (%s)...\n", deployer.Name(), opts.goal)
+		}
+
+		// Remember the running generations so a failed batch can be put back
+		var before []*orchestrator.SystemGeneration
+		if opts.rollbackFailed || opts.confirm {
+			before = readGenerations(batchNodes)
+		}
+
+		var batchErr error
+		reverting := false
+		if opts.confirm {
+			reverting, batchErr = applyConfirmed(deployer, opts.goal, batchNodes, before, opts.confirmTimeout)
+		} else {
+			batchErr = deployer.Apply(opts.goal, names)
+		}
+		if batchErr == nil && opts.reboot {
+			batchErr = rebootNodes(batchNodes, opts.rebootTimeout)
+		}
+		if batchErr == nil && opts.health.Enabled() {
+			fmt.Printf("Checking health of %s...\n", strings.Join(names, ", "))
+			batchErr = checkBatchHealth(&opts.health, batchNodes)
+		}
+		if batchErr != nil {
+			// Nodes with a pending revert timer put themselves back
+			if opts.rollbackFailed && !reverting {
+				rollbackBatch(instances[start:end], before)
+			}
+			if batches == 1 {
+				return batchErr
+			}
+			if remaining := len(nodes) - end; remaining > 0 {
+				return fmt.Errorf("rollout stopped at batch %d/%d (%d node(s) not deployed): %w", batch+1, batches, remaining, batchErr)
+			}
+			return fmt.Errorf("batch %d/%d failed: %w", batch+1, batches, batchErr)
+		}
+
+		// The batch succeeded; a missing record only weakens drift detection
+		if systemPaths == nil {
+			if systemPaths, err = orchestrator.EvalSystemPaths(deployer); err != nil {
+				fmt.Printf("Warning: failed to evaluate deployed system closures: %v\n", err)
+			}
+		}
+		if systemPaths != nil {
+			// Without a reboot, boot-only deploys leave the old system running until the next boot
+			pending := opts.goal == "boot" && !opts.reboot
+			if err := orchestrator.RecordDeployments(projectName, batchNodes, systemPaths, pending); err != nil {
+				fmt.Printf("Warning: failed to record deployed system closures: %v\n", err)
+			}
+			if history.Closures == nil {
+				history.Closures = make(map[string]string)
+			}
+			history.Pending = pending
+			for _, name := range names {
+				history.Closures[name] = systemPaths[name]
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyConfirmed deploys a batch in confirm-or-revert mode: the new system is built first,
+// then each node arms an on-host timer that switches back to its current generation, then
+// the system is activated and each node must accept a fresh SSH login before the timer
+// fires. reverting reports whether nodes were left to revert on their own.
+func applyConfirmed(deployer orchestrator.Deployer, goal string, nodes []*orchestrator.HiveNode,
+	before []*orchestrator.SystemGeneration, timeout time.Duration) (reverting bool, err error) {
+	names := orchestrator.HiveNodeNames(nodes)
+	for i, generation := range before {
+		if generation == nil {
+			return false, fmt.Errorf("cannot deploy %s with --confirm: its current generation is unknown", nodes[i].Name)
+		}
+	}
+
+	// Build before arming, so the timer only has to cover activation and confirmation
+	if err := deployer.Apply("build", names); err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Arming revert timers (%s)...\n", timeout)
+	armedAt := time.Now()
+	for i, node := range nodes {
+		if err := orchestrator.ArmRevertTimer(node.Address, before[i].Number, timeout); err != nil {
+			disarmRevertTimers(nodes[:i])
+			return false, fmt.Errorf("%s: %w", node.Name, err)
+		}
+	}
+
+	if err := deployer.Apply(goal, names); err != nil {
+		fmt.Printf("Activation failed; %s will revert to their previous generation within %s\n", strings.Join(names, ", "), timeout)
+		return true, err
+	}
+
+	fmt.Println("Confirming new SSH logins...")
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *orchestrator.HiveNode) {
+			defer wg.Done()
+			errs[i] = orchestrator.ConfirmDeploy(node.Address, armedAt, timeout)
+		}(i, node)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			fmt.Printf("  %s unconfirmed, reverting to generation %d: %v\n", nodes[i].Name, before[i].Number, err)
+			failures = append(failures, nodes[i].Name)
+		} else {
+			fmt.Printf("  %s confirmed\n", nodes[i].Name)
+		}
+	}
+	if len(failures) > 0 {
+		return true, fmt.Errorf("deploy not confirmed on: %s", strings.Join(failures, ", "))
+	}
+	return false, nil
+}
+
+// disarmRevertTimers cancels the revert timers armed on nodes before a batch was abandoned
+func disarmRevertTimers(nodes []*orchestrator.HiveNode) {
+	for _, node := range nodes {
+		if err := orchestrator.DisarmRevertTimer(node.Address); err != nil {
+			fmt.Printf("Warning: %s: %v\n", node.Name, err)
+		}
+	}
+}
+
+// checkBatchHealth waits for every node of a batch to pass the health checks
+func checkBatchHealth(health *orchestrator.HealthCheck, nodes []*orchestrator.HiveNode) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *orchestrator.HiveNode) {
+			defer wg.Done()
+			errs[i] = health.WaitHealthy(node)
+		}(i, node)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, err.Error())
+		} else {
+			fmt.Printf("  %s healthy\n", nodes[i].Name)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("health check failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// readGenerations reads the running system generation of every node; unreadable nodes are nil
+func readGenerations(nodes []*orchestrator.HiveNode) []*orchestrator.SystemGeneration {
+	generations := make([]*orchestrator.SystemGeneration, len(nodes))
+	for i, node := range nodes {
+		generation, err := orchestrator.ReadSystemGeneration(node.Address)
+		if err != nil {
+			fmt.Printf("Warning: cannot read the generation of %s, it will not be rolled back: %v\n", node.Name, err)
+			continue
+		}
+		generations[i] = generation
+	}
+	return generations
+}
+
+// rollbackBatch switches the nodes of a failed batch back to the generations they ran before it
+func rollbackBatch(instances []*orchestrator.InstanceInfo, before []*orchestrator.SystemGeneration) {
+	var targetInstances []*orchestrator.InstanceInfo
+	var targets []orchestrator.RollbackTarget
+	for i, generation := range before {
+		if generation != nil {
+			targetInstances = append(targetInstances, instances[i])
+			targets = append(targets, orchestrator.RollbackTarget{Generation: generation.Number})
+		}
+	}
+	if len(targetInstances) == 0 {
+		return
+	}
+
+	fmt.Println("Rolling back the failed batch...")
+	for _, result := range orchestrator.RollbackInstances(targetInstances, targets) {
+		if result.Err != nil {
+			fmt.Printf("  %s: rollback failed: %v\n", result.Node, result.Err)
+		} else {
+			fmt.Printf("  %s: generation %s -> %s\n", result.Node, generationNumber(result.Before), generationNumber(result.After))
+		}
+	}
+}
+
+// rebootNodes reboots nodes one at a time, waiting for each to accept SSH logins again
+// before rebooting the next
+func rebootNodes(nodes []*orchestrator.HiveNode, timeout time.Duration) error {
+	for _, node := range nodes {
+		fmt.Printf("Rebooting %s...\n", node.Name)
+		system, err := orchestrator.RebootNode(node.Address, timeout)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node.Name, err)
+		}
+		fmt.Printf("%s is back, running %s\n", node.Name, system)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/iivel-inc/inframan/internal/term"
 	"github.com/spf13/cobra"
 )
 
@@ -16,14 +17,22 @@ func NewSSHCommand() *cobra.Command {
 	var user string
 	var identityFile string
 	var listInstances bool
+	var record bool
+	var broadcast bool
 
 	cmd := &cobra.Command{
-		Use:   "ssh [project[/instance]]",
+		Use:   "ssh [project[/instance] | selector]",
 		Short: "SSH to an instance by project name",
 		Long: `SSH connects to a provisioned instance using its project and instance name.
 
 For single-instance projects, use just the project name.
 For multi-instance projects, use project/instance-name syntax.
+Without arguments on a terminal, an interactive fuzzy picker is shown.
+
+With --broadcast, a shell is opened on every instance matched by a selector
+(comma-separated project, project/instance or project/glob terms). Output is
+prefixed with the host name and every typed line is sent to all enabled hosts;
+':off <host>', ':on <host>', ':only <host>' and ':all' toggle hosts.
 
 Examples:
   # List all available instances
@@ -40,27 +49,49 @@ Examples:
   inframan ssh account1 --user nixos
 
   # Connect with a specific identity file
-  inframan ssh account1 --identity ~/.ssh/id_ed25519`,
-		Args: cobra.MaximumNArgs(1),
+  inframan ssh account1 --identity ~/.ssh/id_ed25519
+
+  # Record the session for audit (replay with 'inframan recordings play')
+  inframan ssh production/web-1 --record
+
+  # Type into every web host at once
+  inframan ssh --broadcast 'production/web-*'`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeInstanceNames,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Handle --list flag
 			if listInstances {
 				return listAllInstances()
 			}
 
-			// If no arguments, show available instances and prompt
+			// If no arguments, offer a picker on a terminal, otherwise list instances
 			if len(args) == 0 {
-				return listAllInstances()
+				if broadcast || !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+					return listAllInstances()
+				}
+				info, err := pickFromAllInstances()
+				if err != nil {
+					return err
+				}
+				if info == nil {
+					return nil
+				}
+				return connectToInstance(info.FullName(), user, identityFile, record || orchestrator.GetRecordSessions())
 			}
 
 			target := args[0]
-			return connectToInstance(target, user, identityFile)
+			if broadcast {
+				return runBroadcast(target, user, identityFile)
+			}
+			return connectToInstance(target, user, identityFile, record || orchestrator.GetRecordSessions())
 		},
 	}
 
-	cmd.Flags().StringVarP(&user, "user", "u", "root", "SSH user")
+	cmd.Flags().StringVarP(&user, "user", "u", orchestrator.GetDeployUser(), "SSH user (defaults to DEPLOY_USER or root)")
 	cmd.Flags().StringVarP(&identityFile, "identity", "i", "", "Path to SSH identity file")
 	cmd.Flags().BoolVarP(&listInstances, "list", "l", false, "List all available instances")
+	cmd.Flags().BoolVarP(&broadcast, "broadcast", "b", false, "Open shells on all instances matched by a selector and broadcast input to them")
+	cmd.Flags().BoolVar(&record, "record", false, "Record the session to .inframan/<project>/recordings/ (also enabled by SSH_RECORD_SESSIONS=1)")
 
 	return cmd
 }
@@ -89,6 +120,63 @@ func listAllInstances() error {
 	return nil
 }
 
+// pickFromAllInstances lets the user choose an instance interactively,
+// returning nil if there are none or the picker was cancelled
+func pickFromAllInstances() (*orchestrator.InstanceInfo, error) {
+	instances, err := orchestrator.GetAllInstances()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instances: %w", err)
+	}
+	if len(instances) == 0 {
+		fmt.Println("No instances found.")
+		fmt.Println("Run 'inframan infra' to provision infrastructure first.")
+		return nil, nil
+	}
+
+	info, err := pickInstance(instances)
+	if err == errPickerCancelled {
+		return nil, nil
+	}
+	if err == term.ErrUnsupported {
+		return nil, listAllInstances()
+	}
+	return info, err
+}
+
+// completeInstanceNames completes project and project/instance names for the ssh command.
+// Only the cached inventory is used: running terraform would be slow and its output
+// would corrupt the completion protocol on stdout.
+func completeInstanceNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	instances, err := orchestrator.GetCachedInstances()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	seen := make(map[string]bool)
+	var completions []string
+	add := func(name, description string) {
+		if seen[name] || !strings.HasPrefix(name, toComplete) {
+			return
+		}
+		seen[name] = true
+		completions = append(completions, fmt.Sprintf("%s\t%s", name, description))
+	}
+
+	for _, inst := range instances {
+		add(inst.FullName(), inst.PublicIP)
+		if inst.InstanceName != "" {
+			// Projects are valid selectors for broadcast mode
+			add(inst.ProjectName, "project")
+		}
+	}
+
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
 // parseTarget parses a target string into project and instance name
 // Examples: "account1" -> ("account1", ""), "production/web-1" -> ("production", "web-1")
 func parseTarget(target string) (projectName, instanceName string) {
@@ -101,7 +189,7 @@ func parseTarget(target string) (projectName, instanceName string) {
 }
 
 // connectToInstance establishes an SSH connection to the specified instance
-func connectToInstance(target, user, identityFile string) error {
+func connectToInstance(target, user, identityFile string, record bool) error {
 	// Parse target into project and instance name
 	projectName, instanceName := parseTarget(target)
 
@@ -114,17 +202,60 @@ func connectToInstance(target, user, identityFile string) error {
 	fmt.Printf("Connecting to %s (%s) as %s...\n", info.FullName(), info.PublicIP, user)
 
 	// Build SSH command arguments
-	sshArgs := []string{"ssh"}
+	sshArgs, err := buildSSHArgs(info, user, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Find ssh binary
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		return fmt.Errorf("ssh not found in PATH: %w", err)
+	}
 
-	// Add SSH config file if SSH_CONFIG_PATH is set (takes precedence)
+	if record {
+		return recordSession(sshPath, sshArgs, info, user)
+	}
+
+	// Replace the current process with ssh (exec)
+	// This gives full terminal control to ssh
+	return syscall.Exec(sshPath, append([]string{"ssh"}, sshArgs...), os.Environ())
+}
+
+// buildSSHArgs returns the ssh arguments (without the program name) for an interactive session
+func buildSSHArgs(info *orchestrator.InstanceInfo, user, identityFile string) ([]string, error) {
+	var sshArgs []string
+
+	// Add SSH config file if specified
 	if sshConfigPath := orchestrator.GetSSHConfigPath(); sshConfigPath != "" {
 		sshArgs = append(sshArgs, "-F", sshConfigPath)
-	} else if identityFile != "" {
-		// Add identity file if specified via flag
-		sshArgs = append(sshArgs, "-i", identityFile)
-	} else if sshKeyPath := orchestrator.GetSSHKeyPath(); sshKeyPath != "" {
-		// Fall back to SSH_KEY_PATH env var
-		sshArgs = append(sshArgs, "-i", sshKeyPath)
+	}
+
+	// Local VMs are reached through their forwarded port
+	vmOpts, err := orchestrator.LocalVMSSHOptions(info)
+	if err != nil {
+		return nil, err
+	}
+	sshArgs = append(sshArgs, vmOpts...)
+
+	// SSH_CONFIG_PATH takes precedence over identities
+	if orchestrator.GetSSHConfigPath() == "" {
+		if identityFile != "" {
+			// Add identity file if specified via flag
+			sshArgs = append(sshArgs, "-i", identityFile)
+		} else {
+			// Fall back to a CA-issued certificate, then SSH_KEY_PATH env var
+			keyPath, certPath, err := orchestrator.SSHIdentity()
+			if err != nil {
+				return nil, err
+			}
+			if keyPath != "" {
+				sshArgs = append(sshArgs, "-i", keyPath)
+			}
+			if certPath != "" {
+				sshArgs = append(sshArgs, "-o", fmt.Sprintf("CertificateFile=%s", certPath))
+			}
+		}
 	}
 
 	// Add common SSH options for convenience (only if not using custom config)
@@ -138,15 +269,35 @@ func connectToInstance(target, user, identityFile string) error {
 
 	// Add target
 	sshTarget := fmt.Sprintf("%s@%s", user, info.PublicIP)
-	sshArgs = append(sshArgs, sshTarget)
+	return append(sshArgs, sshTarget), nil
+}
 
-	// Find ssh binary
-	sshPath, err := exec.LookPath("ssh")
+// recordSession runs ssh under a pseudo-terminal and records the session
+// to .inframan/<project>/recordings/
+func recordSession(sshPath string, sshArgs []string, info *orchestrator.InstanceInfo, user string) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("session recording requires an interactive terminal")
+	}
+
+	size, err := term.GetSize(int(os.Stdin.Fd()))
 	if err != nil {
-		return fmt.Errorf("ssh not found in PATH: %w", err)
+		return err
 	}
 
-	// Replace the current process with ssh (exec)
-	// This gives full terminal control to ssh
-	return syscall.Exec(sshPath, sshArgs, os.Environ())
+	rec, err := orchestrator.NewRecorder(info, user, size)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recording session to %s\n", rec.Path())
+
+	// Force a remote tty since ssh's own stdin is the pty, not the user's terminal
+	cmd := exec.Command(sshPath, append([]string{"-t"}, sshArgs...)...)
+	cmd.Env = os.Environ()
+	sessionErr := orchestrator.RunRecordedSession(cmd, rec)
+
+	if err := rec.Close(); err != nil {
+		return fmt.Errorf("failed to finalize recording: %w", err)
+	}
+	fmt.Printf("Session recorded to %s\n", rec.Path())
+	return sessionErr
 }
@@ -0,0 +1,346 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewStateCommand creates the state command
+func NewStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage the project's Terraform state",
+		Long: `State groups commands that operate on the project's Terraform state.
+
+When TF_BACKEND_JSON points to a managed backend configuration, inframan injects
+the backend block into config.tf.json and derives the state key from the project
+name, so every project gets its own state without hand-written backend blocks.
+
+Before every 'infra' apply and 'destroy', the current state is pulled and kept as
+a snapshot in .inframan/<project>/snapshots/ together with the config hash. The
+newest STATE_SNAPSHOT_RETENTION snapshots (default 20) are kept.`,
+	}
+
+	cmd.AddCommand(newStateMigrateCommand())
+	cmd.AddCommand(newStateSnapshotsCommand())
+	cmd.AddCommand(newStateDiffCommand())
+	cmd.AddCommand(newStateRestoreCommand())
+
+	return cmd
+}
+
+// newStateMigrateCommand creates the state migrate subcommand
+func newStateMigrateCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move local state to the configured managed backend",
+		Long: `Migrate moves the project's local state (.inframan/<project>/terraform/terraform.tfstate)
+to the backend configured by TF_BACKEND_JSON:
+1. Checks that the backend holds no state for the project yet
+2. Regenerates config.tf.json with the managed backend block
+3. Runs terraform init -migrate-state
+4. Pulls the state back from the backend and verifies lineage, serial and resources
+5. Keeps the old local state as terraform.tfstate.migrated
+
+If the backend already holds resources for the project, migrate refuses to run;
+--force overwrites that state with the local one.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backend, err := orchestrator.LoadBackendConfig()
+			if err != nil {
+				return err
+			}
+			if backend == nil {
+				return fmt.Errorf("no managed backend configured; set TF_BACKEND_JSON (mkRunner's backend parameter)")
+			}
+
+			infraConfigJSON := os.Getenv("INFRA_CONFIG_JSON")
+			if infraConfigJSON == "" {
+				return fmt.Errorf("INFRA_CONFIG_JSON environment variable is not set")
+			}
+
+			cmd.SilenceUsage = true
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			terraformExec, err := orchestrator.NewTerraformExecutor()
+			if err != nil {
+				return fmt.Errorf("failed to create terraform executor: %w", err)
+			}
+
+			localState, err := orchestrator.LoadLocalState(terraformExec.GetWorkDir())
+			if err != nil {
+				return err
+			}
+			if localState == nil {
+				fmt.Println("No local state found; nothing to migrate.")
+				return nil
+			}
+			fmt.Printf("Found local state: serial %d, %d resource instances\n", localState.Serial, len(localState.Addresses()))
+
+			// terraform init -migrate-state would silently replace whatever the backend holds
+			fmt.Printf("Checking %s backend for existing state...\n", backend.Type)
+			existing, err := orchestrator.PullBackendState(backend, orchestrator.GetProjectName())
+			if err != nil {
+				return err
+			}
+			if n := len(existing.Addresses()); n > 0 {
+				if !force {
+					return fmt.Errorf("%s backend already holds state for this project (serial %d, %d resource instances); use --force to overwrite it",
+						backend.Type, existing.Serial, n)
+				}
+				fmt.Printf("Overwriting existing backend state (serial %d, %d resource instances)\n", existing.Serial, n)
+			}
+
+			// Regenerate config.tf.json with the managed backend block
+			terranixExec, err := orchestrator.NewTerranixExecutor()
+			if err != nil {
+				return fmt.Errorf("failed to create terranix executor: %w", err)
+			}
+			if _, err := terranixExec.BuildFromConfig(infraConfigJSON); err != nil {
+				return fmt.Errorf("failed to setup workdir: %w", err)
+			}
+
+			fmt.Printf("Migrating state to %s backend...\n", backend.Type)
+			if err := terraformExec.MigrateState(); err != nil {
+				return err
+			}
+
+			fmt.Println("Verifying migrated state...")
+			data, err := terraformExec.StatePull()
+			if err != nil {
+				return err
+			}
+			remoteState, err := orchestrator.ParseState(data)
+			if err != nil {
+				return err
+			}
+			if err := orchestrator.VerifyMigratedState(localState, remoteState); err != nil {
+				return fmt.Errorf("migrated state does not match local state: %w", err)
+			}
+
+			// Keep the old local state out of Terraform's way, but don't delete it
+			localPath := filepath.Join(terraformExec.GetWorkDir(), orchestrator.LocalStateFileName)
+			if _, err := os.Stat(localPath); err == nil {
+				if err := os.Rename(localPath, localPath+".migrated"); err != nil {
+					return fmt.Errorf("failed to move local state aside: %w", err)
+				}
+				fmt.Printf("Old local state kept at %s.migrated\n", localPath)
+			}
+
+			fmt.Println("State migrated successfully!")
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite state the backend already holds for the project")
+
+	return cmd
+}
+
+// takeStateSnapshot snapshots the project's state before an operation and reports it
+func takeStateSnapshot(terraformExec *orchestrator.TerraformExecutor, operation string) error {
+	snapshot, err := terraformExec.TakeStateSnapshot(operation)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot state (use --no-snapshot to skip): %w", err)
+	}
+	if snapshot != nil {
+		fmt.Printf("Saved state snapshot %s\n", snapshot.ID)
+	}
+	return nil
+}
+
+// newStateSnapshotsCommand creates the state snapshots subcommand
+func newStateSnapshotsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "snapshots",
+		Short: "List state snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshots, err := orchestrator.ListSnapshots()
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				fmt.Println("No snapshots found.")
+				return nil
+			}
+
+			fmt.Printf("%-28s %-20s %-8s %-9s %s\n", "SNAPSHOT", "TAKEN", "SERIAL", "RESOURCES", "CONFIG")
+			for _, snapshot := range snapshots {
+				configHash := snapshot.ConfigHash
+				if len(configHash) > 12 {
+					configHash = configHash[:12]
+				}
+				fmt.Printf("%-28s %-20s %-8d %-9d %s\n", snapshot.ID,
+					snapshot.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					snapshot.Serial, snapshot.Resources, configHash)
+			}
+			return nil
+		},
+	}
+}
+
+// newStateDiffCommand creates the state diff subcommand
+func newStateDiffCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <snapshot> [snapshot|current]",
+		Short: "Compare two snapshots, or a snapshot with the current state",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := orchestrator.LoadSnapshotState(args[0])
+			if err != nil {
+				return err
+			}
+
+			toName := "current"
+			if len(args) == 2 {
+				toName = args[1]
+			}
+
+			var to *orchestrator.TerraformState
+			if toName == "current" {
+				terraformExec, err := orchestrator.NewTerraformExecutor()
+				if err != nil {
+					return fmt.Errorf("failed to create terraform executor: %w", err)
+				}
+				data, err := terraformExec.StatePull()
+				if err != nil {
+					return err
+				}
+				if to, err = orchestrator.ParseState(data); err != nil {
+					return err
+				}
+			} else if to, err = orchestrator.LoadSnapshotState(toName); err != nil {
+				return err
+			}
+
+			fmt.Printf("Comparing %s (serial %d) with %s (serial %d)\n\n", args[0], from.Serial, toName, to.Serial)
+			printStateDiff(orchestrator.DiffStates(from, to))
+			return nil
+		},
+	}
+}
+
+// printStateDiff displays the differences between two states
+func printStateDiff(diff *orchestrator.StateDiff) {
+	if diff.Empty() {
+		fmt.Println("No differences.")
+		return
+	}
+
+	for _, address := range diff.Added {
+		fmt.Printf("  + %s\n", address)
+	}
+	for _, address := range diff.Removed {
+		fmt.Printf("  - %s\n", address)
+	}
+
+	changed := make([]string, 0, len(diff.Changed))
+	for address := range diff.Changed {
+		changed = append(changed, address)
+	}
+	sort.Strings(changed)
+	for _, address := range changed {
+		fmt.Printf("  ~ %s (%s)\n", address, strings.Join(diff.Changed[address], ", "))
+	}
+
+	fmt.Printf("\n%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+// newStateRestoreCommand creates the state restore subcommand
+func newStateRestoreCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <snapshot>",
+		Short: "Push a snapshot back as the project's state",
+		Long: `Restore replaces the project's Terraform state with a snapshot:
+1. Shows how the snapshot differs from the current state and asks for confirmation
+2. Snapshots the current state, so the restore itself can be undone
+3. Runs terraform state push -force with the snapshot
+
+Restoring state does not change any infrastructure; run 'inframan infra' afterwards
+to reconcile resources with the restored state.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Read the snapshot up front: taking the pre-restore snapshot may prune it
+			snapshotData, err := orchestrator.ReadSnapshotState(args[0])
+			if err != nil {
+				return err
+			}
+			snapshotState, err := orchestrator.ParseState(snapshotData)
+			if err != nil {
+				return err
+			}
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			terraformExec, err := orchestrator.NewTerraformExecutor()
+			if err != nil {
+				return fmt.Errorf("failed to create terraform executor: %w", err)
+			}
+
+			data, err := terraformExec.StatePull()
+			if err != nil {
+				return err
+			}
+			currentState, err := orchestrator.ParseState(data)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Restoring %s (serial %d) over the current state (serial %d):\n\n", args[0], snapshotState.Serial, currentState.Serial)
+			printStateDiff(orchestrator.DiffStates(currentState, snapshotState))
+			fmt.Println()
+
+			if !yes {
+				ok, err := confirm("Push this snapshot as the project's state?")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					fmt.Println("Restore cancelled.")
+					return nil
+				}
+			}
+
+			if err := takeStateSnapshot(terraformExec, "restore"); err != nil {
+				return err
+			}
+
+			fmt.Println("Pushing snapshot...")
+			if err := terraformExec.StatePush(snapshotData, true); err != nil {
+				return err
+			}
+
+			fmt.Println("State restored successfully!")
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+
+	return cmd
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/iivel-inc/inframan/internal/stateserver"
+	"github.com/spf13/cobra"
+)
+
+// NewStateServerCommand creates the state-server command
+func NewStateServerCommand() *cobra.Command {
+	var listen string
+	var dataDir string
+	var username string
+	var maxVersions int
+
+	cmd := &cobra.Command{
+		Use:   "state-server",
+		Short: "Run a Terraform HTTP state backend",
+		Long: `State-server runs a Terraform HTTP backend (GET, POST, DELETE, LOCK, UNLOCK),
+giving projects shared state and locking without a cloud storage account.
+
+State is stored per project under the data directory, every write is kept as a
+version (list them with GET /<project>?versions, fetch one with ?version=<name>)
+and locks record who holds them.
+
+Point projects at it with a managed http backend:
+
+  backend = { type = "http"; config = { address = "http://state.internal:8080"; }; };
+
+Basic auth is enabled with --username; the password is read from
+INFRAMAN_STATE_SERVER_PASSWORD. Terraform reads its credentials from
+TF_HTTP_USERNAME and TF_HTTP_PASSWORD.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dataDir == "" {
+				inframanDir, err := orchestrator.GetInframanDir()
+				if err != nil {
+					return err
+				}
+				dataDir = filepath.Join(inframanDir, "state-server")
+			}
+
+			password := os.Getenv("INFRAMAN_STATE_SERVER_PASSWORD")
+			if username != "" && password == "" {
+				return fmt.Errorf("INFRAMAN_STATE_SERVER_PASSWORD must be set when --username is used")
+			}
+
+			server, err := stateserver.New(stateserver.Options{
+				DataDir:     dataDir,
+				Username:    username,
+				Password:    password,
+				MaxVersions: maxVersions,
+				Logger:      log.New(os.Stderr, "state-server: ", log.LstdFlags),
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Serving Terraform state from %s on http://%s\n", dataDir, listen)
+			if username == "" {
+				fmt.Println("Warning: basic auth is disabled; use --username for anything but local testing")
+			}
+			// Shut down on interrupt, so the command returns like any other
+			httpServer := &http.Server{Addr: listen, Handler: server}
+			go func() {
+				<-cmd.Context().Done()
+				httpServer.Close()
+			}()
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&dataDir, "data-dir", "", "Directory to store state in (default: .inframan/state-server)")
+	cmd.Flags().StringVar(&username, "username", "", "Require HTTP basic auth with this username")
+	cmd.Flags().IntVar(&maxVersions, "max-versions", stateserver.DefaultMaxVersions, "Number of state versions to keep per project")
+
+	return cmd
+}
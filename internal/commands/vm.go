@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/iivel-inc/inframan/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+// NewVMCommand creates the vm command
+func NewVMCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vm",
+		Short: "Run the project's nodes as local QEMU VMs",
+		Long: `VM boots each node of the project as a local QEMU VM built from the machine
+module (like 'nixos-rebuild build-vm'), with its SSH port forwarded to localhost.
+
+Running VMs are registered as instances of the project <project>-local, with the
+"local" provider. Set INFRAMAN_LOCAL=1 to make deploy, drift, history and the other
+project commands target them instead of the cloud instances: nodes are then built
+as the same QEMU VMs (qemu-vm.nix with the VM's disk image and port), so deploys
+switch the running VMs. Connect with 'inframan ssh <project>-local/<node>'. Host
+aliases such as web-1.<project>.vm are listed per project in
+.inframan/<project>-local/vms.ssh_config, which inframan's ssh invocations include.
+
+Examples:
+  # Boot every node of the cloud project as a VM
+  inframan vm start
+
+  # Iterate on machine.nix against the VMs
+  INFRAMAN_LOCAL=1 inframan deploy
+
+  # Throw a VM away, disk included
+  inframan vm stop web-1 --wipe`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			orchestrator.EnableLocalVMs()
+		},
+	}
+
+	cmd.AddCommand(newVMStartCommand())
+	cmd.AddCommand(newVMStopCommand())
+	cmd.AddCommand(newVMListCommand())
+
+	return cmd
+}
+
+// newVMStartCommand creates the vm start subcommand
+func newVMStartCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var bootTimeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "start [node...]",
+		Short: "Build and boot nodes as local VMs",
+		Long: `Start builds a QEMU VM for each node from the machine module, boots it in the
+background and waits until it accepts SSH logins. Without arguments the nodes are
+the instances of the cloud project. Disk images persist in .inframan/<project>-local/vms/
+until 'inframan vm stop --wipe'.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			nixosModulePath := os.Getenv("NIXOS_MODULE_PATH")
+			if nixosModulePath == "" {
+				return fmt.Errorf("NIXOS_MODULE_PATH environment variable is not set")
+			}
+
+			names := args
+			if len(names) == 0 {
+				var err error
+				if names, err = orchestrator.GetBaseInstanceNames(); err != nil {
+					return fmt.Errorf("failed to get node names from the cloud project (or name the nodes to start): %w", err)
+				}
+			}
+
+			cmd.SilenceUsage = true
+
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			projectName := orchestrator.GetProjectName()
+			nodes, err := orchestrator.HiveNodesForVMs(projectName, names)
+			if err != nil {
+				return err
+			}
+
+			deployer, err := orchestrator.NewDeployer()
+			if err != nil {
+				return fmt.Errorf("failed to create deployer: %w", err)
+			}
+			topology, err := orchestrator.BuildTopology(projectName, nodes)
+			if err != nil {
+				return err
+			}
+			if err := deployer.Generate(nixosModulePath, nodes, topology); err != nil {
+				return fmt.Errorf("failed to generate %s configuration: %w", deployer.Name(), err)
+			}
+
+			fmt.Println("Building VMs...")
+			drvs, err := orchestrator.EvalVMDerivations(deployer)
+			if err != nil {
+				return err
+			}
+
+			for _, node := range nodes {
+				vmPath, err := orchestrator.RealiseDerivation(drvs[node.Name])
+				if err != nil {
+					return err
+				}
+				vm, err := orchestrator.StartLocalVM(projectName, node, vmPath)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Booting %s (localhost:%d, console log %s)...\n", node.Name, vm.Port, vm.Log)
+				if err := orchestrator.WaitForLocalVM(vm, bootTimeout); err != nil {
+					return err
+				}
+				fmt.Printf("%s is up: inframan ssh %s/%s\n", node.Name, projectName, node.Name)
+			}
+
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().DurationVar(&bootTimeout, "boot-timeout", 3*time.Minute, "How long a VM may take to accept SSH logins")
+
+	return cmd
+}
+
+// newVMStopCommand creates the vm stop subcommand
+func newVMStopCommand() *cobra.Command {
+	var lockTimeout time.Duration
+	var wipe bool
+
+	cmd := &cobra.Command{
+		Use:   "stop [node...]",
+		Short: "Stop local VMs",
+		Long: `Stop shuts down the named VMs, or all VMs of the project, and removes them from
+the project's instances. With --wipe their disk images are deleted too, so the next
+start boots a fresh system.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			lock, err := acquireProjectLock(cmd, args, lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			projectName := orchestrator.GetProjectName()
+			names := args
+			if len(names) == 0 {
+				vms, err := orchestrator.LoadLocalVMs(projectName)
+				if err != nil {
+					return err
+				}
+				for name := range vms {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+			}
+			if len(names) == 0 {
+				fmt.Println("No local VMs.")
+				return nil
+			}
+
+			for _, name := range names {
+				if err := orchestrator.StopLocalVM(projectName, name, wipe); err != nil {
+					return err
+				}
+				fmt.Printf("Stopped %s\n", name)
+			}
+			return nil
+		},
+	}
+
+	addLockFlags(cmd, &lockTimeout)
+	cmd.Flags().BoolVar(&wipe, "wipe", false, "Also delete the VMs' disk images")
+
+	return cmd
+}
+
+// newVMListCommand creates the vm list subcommand
+func newVMListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List local VMs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vms, err := orchestrator.LoadLocalVMs(orchestrator.GetProjectName())
+			if err != nil {
+				return err
+			}
+			if len(vms) == 0 {
+				fmt.Println("No local VMs.")
+				return nil
+			}
+
+			names := make([]string, 0, len(vms))
+			for name := range vms {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Printf("%-20s %-8s %-7s %-8s %s\n", "NODE", "STATE", "PORT", "PID", "ADDRESS")
+			for _, name := range names {
+				vm := vms[name]
+				state := "stopped"
+				if vm.Running() {
+					state = "running"
+				}
+				fmt.Printf("%-20s %-8s %-7d %-8d %s\n", name, state, vm.Port, vm.PID, vm.Address)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultActivationGoal is the goal deploy activates with unless --goal says otherwise
+	DefaultActivationGoal = "switch"
+
+	// DefaultRebootTimeout is how long a node may take to come back after a reboot
+	DefaultRebootTimeout = 10 * time.Minute
+
+	// rebootPollInterval is the pause between login attempts while a node reboots
+	rebootPollInterval = 5 * time.Second
+
+	// rebootConnectTimeout bounds each login attempt while a node reboots
+	rebootConnectTimeout = 10 * time.Second
+
+	// bootIDCommand prints an identifier that changes with every boot
+	bootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+)
+
+// ActivationGoals are the goals deploy accepts, as in nixos-rebuild
+var ActivationGoals = []string{"switch", "boot", "test", "dry-activate", "build"}
+
+// ValidateActivationGoal checks that a goal is one of ActivationGoals
+func ValidateActivationGoal(goal string) error {
+	for _, g := range ActivationGoals {
+		if g == goal {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid goal %q (expected %s)", goal, strings.Join(ActivationGoals, ", "))
+}
+
+// GoalChangesNodes reports whether activating with a goal changes what the nodes run or boot
+func GoalChangesNodes(goal string) bool {
+	return goal != "dry-activate" && goal != "build"
+}
+
+// DryActivation lists the units switching to a system would touch on a node
+type DryActivation struct {
+	Stop    []string
+	Restart []string
+	Reload  []string
+	Start   []string
+}
+
+// Empty reports whether switching would not touch any unit
+func (d *DryActivation) Empty() bool {
+	return len(d.Stop)+len(d.Restart)+len(d.Reload)+len(d.Start) == 0
+}
+
+// DryActivate runs switch-to-configuration dry-activate for a system closure that is
+// already on the host and returns the units switching to it would stop, restart, reload or start
+func DryActivate(address, systemPath string) (*DryActivation, error) {
+	output, err := RunRemoteCommand(address, fmt.Sprintf("%s/bin/switch-to-configuration dry-activate 2>&1", systemPath))
+	if err != nil {
+		return nil, fmt.Errorf("dry activation failed: %w", err)
+	}
+	return parseDryActivation(output), nil
+}
+
+// parseDryActivation reads the "would <action> the following units: a, b" lines
+// printed by switch-to-configuration dry-activate
+func parseDryActivation(output string) *DryActivation {
+	result := &DryActivation{}
+	targets := map[string]*[]string{
+		"stop":    &result.Stop,
+		"restart": &result.Restart,
+		"reload":  &result.Reload,
+		"start":   &result.Start,
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "would ") {
+			continue
+		}
+		action, units, found := strings.Cut(strings.TrimPrefix(line, "would "), " the following units: ")
+		target, ok := targets[action]
+		if !found || !ok {
+			continue
+		}
+		for _, unit := range strings.Split(units, ",") {
+			if unit = strings.TrimSpace(unit); unit != "" {
+				*target = append(*target, unit)
+			}
+		}
+	}
+	return result
+}
+
+// RebootNode reboots a host and waits until it accepts new SSH logins again with a new
+// boot ID, returning the system it booted into
+func RebootNode(address string, timeout time.Duration) (string, error) {
+	bootID, err := RunRemoteCommand(address, bootIDCommand)
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %w", err)
+	}
+
+	// The connection usually drops before ssh can report the exit status
+	_, _ = RunRemoteCommand(address, "systemctl reboot")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := Sleep(rebootPollInterval); err != nil {
+			return "", err
+		}
+		current, err := RunRemoteCommandFresh(address, bootIDCommand, rebootConnectTimeout)
+		if err == nil && current != bootID {
+			break
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				return "", fmt.Errorf("%s did not reboot within %s", address, timeout)
+			}
+			return "", fmt.Errorf("%s did not come back within %s: %w", address, timeout, err)
+		}
+	}
+
+	system, err := RunRemoteCommandFresh(address, "readlink -f /run/current-system", rebootConnectTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to read booted system: %w", err)
+	}
+	return system, nil
+}
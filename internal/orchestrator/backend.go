@@ -0,0 +1,213 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBackendKeyPrefix is the prefix of derived state keys when the backend config sets none
+const DefaultBackendKeyPrefix = "inframan"
+
+// BackendConfig is the managed Terraform backend of a project, read from TF_BACKEND_JSON.
+// Config holds the backend's own settings (bucket, region, address, ...); the setting that
+// identifies the state object (key, prefix, address, ...) is derived from the project name.
+type BackendConfig struct {
+	Type      string                 `json:"type"`
+	KeyPrefix string                 `json:"keyPrefix,omitempty"`
+	Config    map[string]interface{} `json:"config"`
+}
+
+// GetBackendConfigPath returns the managed backend config path from environment, or empty string if not set
+func GetBackendConfigPath() string {
+	return os.Getenv("TF_BACKEND_JSON")
+}
+
+// LoadBackendConfig reads the managed backend configuration, returning nil if none is configured
+func LoadBackendConfig() (*BackendConfig, error) {
+	path := GetBackendConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backend config: %w", err)
+	}
+
+	var backend BackendConfig
+	if err := json.Unmarshal(data, &backend); err != nil {
+		return nil, fmt.Errorf("failed to parse backend config %s: %w", path, err)
+	}
+	if backend.Type == "" {
+		return nil, fmt.Errorf("backend config %s has no type", path)
+	}
+	if backend.Config == nil {
+		backend.Config = make(map[string]interface{})
+	}
+	return &backend, nil
+}
+
+// Render returns the backend block for a project, deriving the state location from
+// the project name unless the config already sets it
+func (b *BackendConfig) Render(projectName string) (map[string]interface{}, error) {
+	block := make(map[string]interface{}, len(b.Config)+3)
+	for k, v := range b.Config {
+		block[k] = v
+	}
+
+	prefix := b.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultBackendKeyPrefix
+	}
+	stateKey := fmt.Sprintf("%s/%s/terraform.tfstate", prefix, projectName)
+
+	setDefault := func(key string, value interface{}) {
+		if _, ok := block[key]; !ok {
+			block[key] = value
+		}
+	}
+
+	switch b.Type {
+	case "s3", "azurerm", "oss", "cos":
+		setDefault("key", stateKey)
+	case "gcs":
+		setDefault("prefix", fmt.Sprintf("%s/%s", prefix, projectName))
+	case "consul":
+		setDefault("path", fmt.Sprintf("%s/%s", prefix, projectName))
+	case "kubernetes":
+		setDefault("secret_suffix", fmt.Sprintf("%s-%s", prefix, projectName))
+	case "http":
+		// The address is a base URL; each project gets its own state resource under it
+		base, ok := block["address"].(string)
+		if !ok || base == "" {
+			return nil, fmt.Errorf("http backend config requires an address")
+		}
+		address := fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), projectName)
+		block["address"] = address
+		setDefault("lock_address", address)
+		setDefault("unlock_address", address)
+		setDefault("lock_method", "LOCK")
+		setDefault("unlock_method", "UNLOCK")
+	case "local":
+		// Local state already lives in the project's terraform directory
+	default:
+		return nil, fmt.Errorf("unsupported managed backend type %q", b.Type)
+	}
+
+	return block, nil
+}
+
+// InjectBackend adds the managed backend block to a Terraform JSON config file
+func InjectBackend(configPath string, backend *BackendConfig, projectName string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read terraform config: %w", err)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse terraform config: %w", err)
+	}
+
+	block, err := backend.Render(projectName)
+	if err != nil {
+		return err
+	}
+
+	// Terraform JSON accepts "terraform" as an object or a list of objects
+	var settings map[string]interface{}
+	switch existing := config["terraform"].(type) {
+	case nil:
+		settings = make(map[string]interface{})
+		config["terraform"] = settings
+	case map[string]interface{}:
+		settings = existing
+	case []interface{}:
+		for _, item := range existing {
+			if m, ok := item.(map[string]interface{}); ok {
+				if _, ok := m["backend"]; ok {
+					return fmt.Errorf("terraform config already defines a backend; remove it or unset TF_BACKEND_JSON")
+				}
+			}
+		}
+		settings = make(map[string]interface{})
+		config["terraform"] = append(existing, settings)
+	default:
+		return fmt.Errorf("unexpected terraform block in config")
+	}
+
+	if _, ok := settings["backend"]; ok {
+		return fmt.Errorf("terraform config already defines a backend; remove it or unset TF_BACKEND_JSON")
+	}
+	settings["backend"] = map[string]interface{}{backend.Type: block}
+
+	output, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode terraform config: %w", err)
+	}
+	if err := os.WriteFile(configPath, output, 0644); err != nil {
+		return fmt.Errorf("failed to write terraform config: %w", err)
+	}
+	return nil
+}
+
+// applyManagedBackend injects the configured backend, if any, into a generated config file
+func applyManagedBackend(configPath string) error {
+	backend, err := LoadBackendConfig()
+	if err != nil {
+		return err
+	}
+	if backend == nil {
+		return nil
+	}
+	return InjectBackend(configPath, backend, GetProjectName())
+}
+
+// PullBackendState returns the state a project's managed backend currently holds. It runs
+// terraform in a scratch directory configured with just the backend, so the project's own
+// working directory stays initialized for its current backend.
+func PullBackendState(backend *BackendConfig, projectName string) (*TerraformState, error) {
+	block, err := backend.Render(projectName)
+	if err != nil {
+		return nil, err
+	}
+	config, err := json.MarshalIndent(map[string]interface{}{
+		"terraform": map[string]interface{}{
+			"backend": map[string]interface{}{backend.Type: block},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode terraform config: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "inframan-backend-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "config.tf.json"), config, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write terraform config: %w", err)
+	}
+
+	initCmd := exec.Command("terraform", "init", "-input=false")
+	initCmd.Dir = dir
+	initCmd.Env = os.Environ()
+	if output, err := initCmd.CombinedOutput(); err != nil {
+		os.Stderr.Write(output)
+		return nil, fmt.Errorf("failed to initialize %s backend: %w", backend.Type, err)
+	}
+
+	pull := exec.Command("terraform", "state", "pull")
+	pull.Dir = dir
+	pull.Stderr = os.Stderr
+	pull.Env = os.Environ()
+	data, err := pull.Output()
+	if err != nil {
+		return nil, fmt.Errorf("terraform state pull failed: %w", err)
+	}
+	return ParseState(data)
+}
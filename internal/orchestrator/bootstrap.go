@@ -0,0 +1,212 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BootstrapFileName is the name of the file recording which instances were installed by bootstrap
+const BootstrapFileName = "bootstrapped.json"
+
+// BootstrapRecord records the installation of NixOS on an instance
+type BootstrapRecord struct {
+	Address    string    `json:"address"`
+	Timestamp  time.Time `json:"timestamp"`
+	SystemPath string    `json:"systemPath"`
+}
+
+// GetDiskoConfigPath returns the disko configuration module from DISKO_CONFIG_PATH,
+// or empty string if the project's instances already run NixOS
+func GetDiskoConfigPath() string {
+	return os.Getenv("DISKO_CONFIG_PATH")
+}
+
+// getBootstrapPath returns the path of a project's bootstrap record
+// Structure: .inframan/<project-name>/bootstrapped.json
+func getBootstrapPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, BootstrapFileName), nil
+}
+
+// LoadBootstrapped returns the bootstrap records of a project by node name
+func LoadBootstrapped(projectName string) (map[string]*BootstrapRecord, error) {
+	path, err := getBootstrapPath(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]*BootstrapRecord)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", BootstrapFileName, err)
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", BootstrapFileName, err)
+	}
+	return records, nil
+}
+
+// RecordBootstrapped records that NixOS was installed on a node
+func RecordBootstrapped(projectName string, node *HiveNode, systemPath string) error {
+	records, err := LoadBootstrapped(projectName)
+	if err != nil {
+		return err
+	}
+	records[node.Name] = &BootstrapRecord{
+		Address:    node.Address,
+		Timestamp:  time.Now().UTC(),
+		SystemPath: systemPath,
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", BootstrapFileName, err)
+	}
+	path, err := getBootstrapPath(projectName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", BootstrapFileName, err)
+	}
+	return nil
+}
+
+// FilterBootstrapped splits a project's instances into those running NixOS and those
+// still waiting for bootstrap. Without DISKO_CONFIG_PATH every instance counts as installed.
+// An instance whose address changed since its bootstrap was replaced and is pending again.
+func FilterBootstrapped(projectName string, instances []*InstanceInfo) (installed, pending []*InstanceInfo, err error) {
+	// Local VMs boot straight into NixOS
+	if GetDiskoConfigPath() == "" || LocalVMsEnabled() {
+		return instances, nil, nil
+	}
+
+	records, err := LoadBootstrapped(projectName)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes := HiveNodesFromInstances(instances)
+	for i, inst := range instances {
+		if record, ok := records[nodes[i].Name]; ok && record.Address == inst.PublicIP {
+			installed = append(installed, inst)
+		} else {
+			pending = append(pending, inst)
+		}
+	}
+	return installed, pending, nil
+}
+
+// AssignDiskoConfig makes every node import the disko configuration, if one is configured.
+// Local VMs use the disk layout of the VM instead.
+func AssignDiskoConfig(nodes []*HiveNode) error {
+	diskoConfigPath := GetDiskoConfigPath()
+	if diskoConfigPath == "" || LocalVMsEnabled() {
+		return nil
+	}
+	absPath, err := filepath.Abs(diskoConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		return fmt.Errorf("DISKO_CONFIG_PATH file does not exist: %s", diskoConfigPath)
+	}
+	for _, node := range nodes {
+		node.DiskoConfig = absPath
+	}
+	return nil
+}
+
+// KexecNode boots a node into the NixOS installer with nixos-anywhere, logging in as user
+func KexecNode(node *HiveNode, user string) error {
+	return runNixosAnywhere(fmt.Sprintf("%s@%s", user, node.Address), "--phases", "kexec")
+}
+
+// InstallNode partitions a node booted into the installer with its disko script,
+// installs the system closure and reboots into it
+func InstallNode(node *HiveNode, diskoScript, systemPath string) error {
+	return runNixosAnywhere(fmt.Sprintf("root@%s", node.Address),
+		"--phases", "disko,install,reboot",
+		"--store-paths", diskoScript, systemPath)
+}
+
+// runNixosAnywhere runs nixos-anywhere against a target with the run's SSH identity
+func runNixosAnywhere(target string, args ...string) error {
+	keyPath, certPath, err := SSHIdentity()
+	if err != nil {
+		return err
+	}
+	if keyPath != "" {
+		args = append(args, "-i", keyPath)
+	}
+	if certPath != "" {
+		args = append(args, "--ssh-option", fmt.Sprintf("CertificateFile=%s", certPath))
+	}
+	args = append(args, target)
+
+	cmd := exec.Command("nixos-anywhere", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmd.Env = os.Environ()
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nixos-anywhere failed for %s: %w", target, err)
+	}
+	return nil
+}
+
+// ForgetHostKey removes an address from known_hosts; kexec and installation give the
+// host a new key, which StrictHostKeyChecking=accept-new would otherwise refuse
+func ForgetHostKey(address string) {
+	// Errors only mean there was no entry to remove
+	_ = exec.Command("ssh-keygen", "-R", address).Run()
+}
+
+// EvalBootstrapDerivations evaluates the derivations of a node's system and disko script
+func EvalBootstrapDerivations(d Deployer, nodeName string) (systemDrv, diskoDrv string, err error) {
+	expr := fmt.Sprintf(`{ nodes, ... }: let build = nodes.%s.config.system.build; in {
+  system = build.toplevel.drvPath;
+  disko = build.diskoScript.drvPath;
+}`, nixString(nodeName))
+	output, err := d.Eval(expr)
+	if err != nil {
+		return "", "", err
+	}
+
+	var drvs struct {
+		System string `json:"system"`
+		Disko  string `json:"disko"`
+	}
+	if err := json.Unmarshal(output, &drvs); err != nil {
+		return "", "", fmt.Errorf("failed to parse derivations: %w", err)
+	}
+	return drvs.System, drvs.Disko, nil
+}
+
+// RealiseDerivation builds a derivation locally and returns its output path
+func RealiseDerivation(drvPath string) (string, error) {
+	cmd := exec.Command("nix-store", "--realise", drvPath)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to build %s: %w", drvPath, err)
+	}
+	outPath := strings.TrimSpace(string(output))
+	if outPath == "" {
+		return "", fmt.Errorf("failed to build %s: nix-store printed no output path", drvPath)
+	}
+	return outPath, nil
+}
@@ -0,0 +1,172 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// storeDir is the Nix store prefix of every store path
+const storeDir = "/nix/store/"
+
+// Closure is the set of store paths a system depends on, with their NAR sizes
+type Closure struct {
+	Path  string
+	Sizes map[string]int64 // store path -> size in bytes
+}
+
+// Size returns the total size of the closure in bytes
+func (c *Closure) Size() int64 {
+	var total int64
+	for _, size := range c.Sizes {
+		total += size
+	}
+	return total
+}
+
+// packages groups the closure's store paths by package name, nvd-style, mapping each
+// name to its sorted versions (an unversioned path has the version "")
+func (c *Closure) packages() map[string][]string {
+	versions := make(map[string]map[string]bool)
+	for path := range c.Sizes {
+		name, version := parseStorePath(path)
+		if versions[name] == nil {
+			versions[name] = make(map[string]bool)
+		}
+		versions[name][version] = true
+	}
+
+	packages := make(map[string][]string, len(versions))
+	for name, set := range versions {
+		for version := range set {
+			packages[name] = append(packages[name], version)
+		}
+		sort.Strings(packages[name])
+	}
+	return packages
+}
+
+// parseStorePath splits a store path into package name and version; the version starts
+// at the first dash followed by a digit, as in nix's parseDrvName
+func parseStorePath(path string) (name, version string) {
+	base := strings.TrimPrefix(path, storeDir)
+	// Drop the hash
+	if i := strings.IndexByte(base, '-'); i >= 0 {
+		base = base[i+1:]
+	}
+	for i := 0; i+1 < len(base); i++ {
+		if base[i] == '-' && base[i+1] >= '0' && base[i+1] <= '9' {
+			return base[:i], base[i+1:]
+		}
+	}
+	return base, ""
+}
+
+// QueryClosure reads the closure of a store path on a host
+func QueryClosure(address, path string) (*Closure, error) {
+	// Requisites first, then their sizes in the same order
+	command := fmt.Sprintf("paths=$(nix-store --query --requisites %s) && echo \"$paths\" && echo \"$paths\" | xargs nix-store --query --size", path)
+	output, err := RunRemoteCommand(address, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closure of %s: %w", path, err)
+	}
+
+	lines := strings.Fields(output)
+	if len(lines)%2 != 0 {
+		return nil, fmt.Errorf("failed to query closure of %s: unexpected nix-store output", path)
+	}
+	count := len(lines) / 2
+	closure := &Closure{Path: path, Sizes: make(map[string]int64, count)}
+	for i := 0; i < count; i++ {
+		size, err := strconv.ParseInt(lines[count+i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse size of %s: %w", lines[i], err)
+		}
+		closure.Sizes[lines[i]] = size
+	}
+	return closure, nil
+}
+
+// PackageChange is a package whose versions differ between two closures
+type PackageChange struct {
+	Name        string
+	OldVersions []string // empty if added
+	NewVersions []string // empty if removed
+}
+
+// ClosureDiff compares a node's running system with a new one
+type ClosureDiff struct {
+	Current *Closure
+	New     *Closure
+	Added   []PackageChange
+	Removed []PackageChange
+	Changed []PackageChange
+}
+
+// Unchanged reports whether the node already runs the new system
+func (d *ClosureDiff) Unchanged() bool {
+	return d.Current.Path == d.New.Path
+}
+
+// DiffNodeClosure compares the system running on a host (/run/current-system) with a
+// new system closure that is already on the host
+func DiffNodeClosure(address, newSystem string) (*ClosureDiff, error) {
+	currentSystem, err := RunRemoteCommand(address, "readlink -f /run/current-system")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current system: %w", err)
+	}
+	current, err := QueryClosure(address, currentSystem)
+	if err != nil {
+		return nil, err
+	}
+	next, err := QueryClosure(address, newSystem)
+	if err != nil {
+		return nil, err
+	}
+	return diffClosures(current, next), nil
+}
+
+// diffClosures lists the packages added, removed or changed in version between two closures
+func diffClosures(current, next *Closure) *ClosureDiff {
+	diff := &ClosureDiff{Current: current, New: next}
+	oldPackages, newPackages := current.packages(), next.packages()
+
+	for name, newVersions := range newPackages {
+		oldVersions, ok := oldPackages[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, PackageChange{Name: name, NewVersions: newVersions})
+		case strings.Join(oldVersions, " ") != strings.Join(newVersions, " "):
+			diff.Changed = append(diff.Changed, PackageChange{Name: name, OldVersions: oldVersions, NewVersions: newVersions})
+		}
+	}
+	for name, oldVersions := range oldPackages {
+		if _, ok := newPackages[name]; !ok {
+			diff.Removed = append(diff.Removed, PackageChange{Name: name, OldVersions: oldVersions})
+		}
+	}
+
+	for _, changes := range [][]PackageChange{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	}
+	return diff
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. "1.2 GiB"
+func FormatBytes(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %ciB", sign, value, "KMGTP"[exp])
+}
@@ -1,6 +1,7 @@
 package orchestrator
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -8,9 +9,10 @@ import (
 	"strings"
 )
 
-// ColmenaExecutor handles colmena command execution
+// ColmenaExecutor deploys with Colmena (the default deployer)
 type ColmenaExecutor struct {
-	workDir string
+	workDir  string
+	hivePath string
 }
 
 // NewColmenaExecutor creates a new colmena executor
@@ -25,57 +27,141 @@ func NewColmenaExecutor() (*ColmenaExecutor, error) {
 		return nil, err
 	}
 
-	return &ColmenaExecutor{workDir: workDir}, nil
+	return &ColmenaExecutor{workDir: workDir, hivePath: filepath.Join(workDir, HiveFileName)}, nil
 }
 
-// GenerateHive creates an ephemeral hive.nix with the target IP injected
-func (c *ColmenaExecutor) GenerateHive(modulePath, targetIP string) (string, error) {
-	// Ensure workdir exists
-	if err := os.MkdirAll(c.workDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create workdir: %w", err)
+// LegacyNodeName is the hive node name used for single-instance projects (legacy public_ip output)
+const LegacyNodeName = "target-node"
+
+// HiveNode is a machine in the generated hive, described the same way to every deployer
+type HiveNode struct {
+	Name    string    // node name
+	Address string    // target host
+	User    string    // user the deployer logs in as (default: root)
+	Tags    []string  // selectors such as project-<name> (colmena --on @tag)
+	Secrets []*Secret // uploaded as deployment keys
+
+	HardwareConfig string // imported next to the user's module when set
+	DiskoConfig    string // imported for instances installed by bootstrap
+
+	VM *LocalVM // QEMU VM the node runs as, for instances of a local project
+}
+
+// HiveNodesFromInstances maps a project's instances to hive nodes, naming each node
+// after its instance (or LegacyNodeName for a single public_ip instance)
+func HiveNodesFromInstances(instances []*InstanceInfo) []*HiveNode {
+	projectTag := fmt.Sprintf("project-%s", GetProjectName())
+	nodes := make([]*HiveNode, len(instances))
+	for i, inst := range instances {
+		name := inst.InstanceName
+		if name == "" {
+			name = LegacyNodeName
+		}
+		tags := []string{projectTag}
+		if inst.Provider != "" {
+			tags = append(tags, fmt.Sprintf("provider-%s", inst.Provider))
+		}
+		nodes[i] = &HiveNode{Name: name, Address: inst.PublicIP, User: GetDeployUser(), Tags: tags}
+		if inst.Provider == LocalProvider {
+			nodes[i].VM = lookupLocalVM(inst.ProjectName, name)
+		}
+	}
+	return nodes
+}
+
+// HiveNodeNames returns the names of the nodes
+func HiveNodeNames(nodes []*HiveNode) []string {
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name
 	}
+	return names
+}
 
-	// Convert module path to absolute path for Nix
-	absModulePath, err := filepath.Abs(modulePath)
+// deployUser returns the user a deployer logs in to a node as
+func (n *HiveNode) deployUser() string {
+	if n.User == "" {
+		return DefaultDeployUser
+	}
+	return n.User
+}
+
+// Name returns the deployer name
+func (c *ColmenaExecutor) Name() string {
+	return DeployerColmena
+}
+
+// Generate writes hive.nix for the nodes
+func (c *ColmenaExecutor) Generate(modulePath string, nodes []*HiveNode, topology *Topology) error {
+	hivePath, err := c.GenerateHive(modulePath, nodes, topology)
 	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
+	c.hivePath = hivePath
+	return nil
+}
 
-	// Build SSH options for the hive (each argument must be a separate list element)
-	var sshOptions []string
-	if sshConfigPath := GetSSHConfigPath(); sshConfigPath != "" {
-		sshOptions = append(sshOptions, "-F", sshConfigPath)
+// GenerateHive creates an ephemeral hive.nix with one node per target, with the target IPs injected
+// and the project topology passed to the modules as the `inframan` argument
+func (c *ColmenaExecutor) GenerateHive(modulePath string, nodes []*HiveNode, topology *Topology) (string, error) {
+	// Ensure workdir exists
+	if err := os.MkdirAll(c.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workdir: %w", err)
 	}
-	if sshKeyPath := GetSSHKeyPath(); sshKeyPath != "" {
-		sshOptions = append(sshOptions, "-i", sshKeyPath)
+	if err := writeTopology(c.workDir, topology); err != nil {
+		return "", err
 	}
-	// Add convenience option for new hosts
-	sshOptions = append(sshOptions, "-o", "StrictHostKeyChecking=accept-new")
 
-	// Format SSH options as Nix list
-	quotedOpts := make([]string, len(sshOptions))
-	for i, opt := range sshOptions {
-		quotedOpts[i] = fmt.Sprintf("\"%s\"", opt)
+	// Build SSH options for the hive (each argument must be a separate list element)
+	sshOptions, err := SSHOptions()
+	if err != nil {
+		return "", err
 	}
-	sshOptsNix := fmt.Sprintf("[ %s ]", strings.Join(quotedOpts, " "))
+	sshOptsNix := nixStringList(sshOptions)
 
-	// Generate the hive content
-	nixPath := fmt.Sprintf("\"%s\"", absModulePath)
-	hiveContent := fmt.Sprintf(`{
-  meta = {
-    nixpkgs = import <nixpkgs> { system = "x86_64-linux"; };
-  };
+	// Generate the node definitions
+	var nodeDefs, nodeArgs strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&nodeArgs, "\n      %s = { inframan = topology // { instance = %s; address = %s; }; };",
+			nixString(node.Name), nixString(node.Name), nixString(node.Address))
 
+		body, err := nodeModuleNix(modulePath, node)
+		if err != nil {
+			return "", err
+		}
+		// Secrets are only referenced by path or command; values are read locally at upload time
+		keysLine := ""
+		if len(node.Secrets) > 0 {
+			keysNix, err := deploymentKeysNix(node.Secrets)
+			if err != nil {
+				return "", err
+			}
+			keysLine = fmt.Sprintf("\n    deployment.keys = %s;", keysNix)
+		}
+		fmt.Fprintf(&nodeDefs, `
   # Define the node
-  target-node = { ... }: {
-    imports = [ (import %s) ]; # Import the user's module
-    deployment.targetHost = "%s"; # Injected IP
-    deployment.targetUser = "root";
+  %s = { inframan, ... }: {
+%s    deployment.targetHost = %s; # Injected IP
+    deployment.targetUser = %s;
     deployment.buildOnTarget = true; # Build on remote instance, not locally
     deployment.sshOptions = %s;
+    deployment.tags = %s;%s
   };
-}
-`, nixPath, targetIP, sshOptsNix)
+`, nixString(node.Name), body, nixString(node.Address), nixString(node.deployUser()), sshOptsNix, nixStringList(node.Tags), keysLine)
+	}
+
+	// Generate the hive content
+	hiveContent := fmt.Sprintf(`let
+  # Project, instances and selected Terraform outputs (see topology.json)
+  topology = builtins.fromJSON (builtins.readFile ./%s);
+in {
+  meta = {
+    nixpkgs = import <nixpkgs> { system = "x86_64-linux"; };
+    nodeSpecialArgs = {%s
+    };
+  };
+%s}
+`, TopologyFileName, nodeArgs.String(), nodeDefs.String())
 
 	// Write to hive.nix
 	hivePath := filepath.Join(c.workDir, HiveFileName)
@@ -86,9 +172,23 @@ func (c *ColmenaExecutor) GenerateHive(modulePath, targetIP string) (string, err
 	return hivePath, nil
 }
 
-// Apply runs colmena apply with the generated hive
-func (c *ColmenaExecutor) Apply(hivePath string) error {
-	args := []string{"apply", "--on", "target-node", "-f", hivePath}
+// Eval runs colmena eval with an expression over the hive's nodes
+func (c *ColmenaExecutor) Eval(expr string) ([]byte, error) {
+	cmd := exec.Command("colmena", "eval", "-f", c.hivePath, "-E", expr)
+	cmd.Dir = c.workDir
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("colmena eval failed: %w", err)
+	}
+	return output, nil
+}
+
+// Apply runs colmena apply with a specific goal (build, push, switch, boot, ...) on the given nodes
+func (c *ColmenaExecutor) Apply(goal string, nodeNames []string) error {
+	args := []string{"apply", goal, "--on", strings.Join(nodeNames, ","), "-f", c.hivePath}
 
 	cmd := exec.Command("colmena", args...)
 	cmd.Dir = c.workDir
@@ -97,25 +197,70 @@ func (c *ColmenaExecutor) Apply(hivePath string) error {
 	cmd.Stdin = os.Stdin
 
 	// Build NIX_SSHOPTS for nix-copy-closure (colmena uses this for copying derivations)
-	env := os.Environ()
-	var sshOpts []string
-	if sshConfigPath := GetSSHConfigPath(); sshConfigPath != "" {
-		sshOpts = append(sshOpts, "-F", sshConfigPath)
+	// Shares the same ControlMaster as colmena's own ssh connections
+	env, err := deployerSSHEnv()
+	if err != nil {
+		return err
 	}
-	if sshKeyPath := GetSSHKeyPath(); sshKeyPath != "" {
-		sshOpts = append(sshOpts, "-i", sshKeyPath)
+	cmd.Env = env
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("colmena apply %s failed: %w", goal, err)
+	}
+
+	return nil
+}
+
+// UploadKeys runs colmena upload-keys, replacing the deployment keys on the given
+// nodes without building or activating a new system
+func (c *ColmenaExecutor) UploadKeys(nodeNames []string) error {
+	cmd := exec.Command("colmena", "upload-keys", "--on", strings.Join(nodeNames, ","), "-f", c.hivePath)
+	cmd.Dir = c.workDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	env, err := deployerSSHEnv()
+	if err != nil {
+		return err
 	}
-	sshOpts = append(sshOpts, "-o", "StrictHostKeyChecking=accept-new")
-	env = append(env, fmt.Sprintf("NIX_SSHOPTS=%s", strings.Join(sshOpts, " ")))
 	cmd.Env = env
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("colmena apply failed: %w", err)
+		return fmt.Errorf("colmena upload-keys failed: %w", err)
 	}
 
 	return nil
 }
 
+// deploymentKeysNix renders secrets as the value of deployment.keys
+func deploymentKeysNix(secrets []*Secret) (string, error) {
+	keys := make(map[string]interface{}, len(secrets))
+	for _, secret := range secrets {
+		keys[secret.Name] = secret.DeploymentKey()
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode deployment keys: %w", err)
+	}
+	return fmt.Sprintf("builtins.fromJSON %s", nixString(string(data))), nil
+}
+
+// nixString quotes a string as a Nix string literal
+func nixString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(s) + `"`
+}
+
+// nixStringList formats strings as a Nix list of string literals
+func nixStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = nixString(v)
+	}
+	return fmt.Sprintf("[ %s ]", strings.Join(quoted, " "))
+}
+
 // ApplyWithTag runs colmena apply for a specific tag (legacy support)
 func (c *ColmenaExecutor) ApplyWithTag(project string) error {
 	tag := fmt.Sprintf("@project-%s", project)
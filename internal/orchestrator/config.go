@@ -16,6 +16,15 @@ const (
 	// ColmenaSubdir is the subdirectory for colmena hive files
 	ColmenaSubdir = "colmena"
 
+	// SSHSubdir is the subdirectory for issued SSH certificates
+	SSHSubdir = "ssh"
+
+	// RecordingsSubdir is the subdirectory for recorded SSH sessions
+	RecordingsSubdir = "recordings"
+
+	// SnapshotsSubdir is the subdirectory for state snapshots taken before changes
+	SnapshotsSubdir = "snapshots"
+
 	// ConfigFileName is the name of the terraform config file
 	ConfigFileName = "config.tf.json"
 
@@ -26,11 +35,15 @@ const (
 	DefaultProjectName = "default"
 )
 
-// GetProjectName returns the project name from environment or default
+// GetProjectName returns the project name from environment or default.
+// With INFRAMAN_LOCAL=1 it is the project holding the local VMs, <project>-local.
 func GetProjectName() string {
 	projectName := os.Getenv("PROJECT_NAME")
 	if projectName == "" {
-		return DefaultProjectName
+		projectName = DefaultProjectName
+	}
+	if LocalVMsEnabled() {
+		return projectName + LocalProjectSuffix
 	}
 	return projectName
 }
@@ -138,6 +151,12 @@ func GetAllProjectDirs() ([]string, error) {
 			continue
 		}
 
+		// Local VM projects have no Terraform state, only a VM inventory
+		if IsLocalProject(entry.Name()) {
+			projects = append(projects, entry.Name())
+			continue
+		}
+
 		// Check if this project has been initialized (works with any backend type)
 		// We check for .terraform/ directory (created by terraform init) or config.tf.json
 		terraformDir := filepath.Join(inframanDir, entry.Name(), TerraformSubdir)
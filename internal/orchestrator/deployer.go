@@ -0,0 +1,333 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DeployerColmena deploys with colmena apply (the default)
+	DeployerColmena = "colmena"
+
+	// DeployerNixosRebuild deploys with nixos-rebuild --target-host, one node at a time
+	DeployerNixosRebuild = "nixos-rebuild"
+
+	// DeployerDeployRS deploys with deploy-rs
+	DeployerDeployRS = "deploy-rs"
+
+	// NodesFileName is the backend-neutral node definitions file used by nixos-rebuild and deploy-rs
+	NodesFileName = "nodes.nix"
+
+	// DefaultDeployUser is the user deployers log in as unless DEPLOY_USER says otherwise
+	DefaultDeployUser = "root"
+)
+
+// Deployer builds the NixOS systems of hive nodes and activates them on the hosts.
+// Every backend receives the same node data (targets, users, SSH options, secrets,
+// tags, topology), so machine modules do not depend on the backend.
+type Deployer interface {
+	// Name returns the backend name as used in DEPLOYER
+	Name() string
+
+	// Generate writes the backend's configuration for the nodes
+	Generate(modulePath string, nodes []*HiveNode, topology *Topology) error
+
+	// Eval evaluates a function of { nodes, ... } over the generated nodes and returns its JSON value
+	Eval(expr string) ([]byte, error)
+
+	// Apply builds the systems of the given nodes and activates them with one of ActivationGoals
+	Apply(goal string, nodeNames []string) error
+
+	// UploadKeys replaces the secrets of the given nodes without activating a new system
+	UploadKeys(nodeNames []string) error
+}
+
+// GetDeployerName returns the deployment backend from DEPLOYER, or colmena if not set
+func GetDeployerName() string {
+	if name := os.Getenv("DEPLOYER"); name != "" {
+		return name
+	}
+	return DeployerColmena
+}
+
+// GetDeployUser returns the user deployers and remote commands log in as from
+// DEPLOY_USER, or root if not set. Other users act as root through sudo.
+func GetDeployUser() string {
+	if user := os.Getenv("DEPLOY_USER"); user != "" {
+		return user
+	}
+	return DefaultDeployUser
+}
+
+// NewDeployer creates the deployer selected by DEPLOYER for the current project
+func NewDeployer() (Deployer, error) {
+	switch name := GetDeployerName(); name {
+	case DeployerColmena:
+		return NewColmenaExecutor()
+	case DeployerNixosRebuild:
+		return NewNixosRebuildExecutor()
+	case DeployerDeployRS:
+		return NewDeployRSExecutor()
+	default:
+		return nil, fmt.Errorf("unknown DEPLOYER %q (expected %s, %s or %s)", name, DeployerColmena, DeployerNixosRebuild, DeployerDeployRS)
+	}
+}
+
+// ValidateDeployerGoal checks that a deployment backend can activate with a goal,
+// so an unsupported goal fails before anything is evaluated or deployed
+func ValidateDeployerGoal(name, goal string) error {
+	if name == DeployerDeployRS && goal == "test" {
+		return fmt.Errorf("deploy-rs has no test goal (use switch, boot, dry-activate or build, or another DEPLOYER)")
+	}
+	return nil
+}
+
+// getDeployerDir returns the working directory of a deployment backend
+// Structure: .inframan/<project-name>/<backend>/
+func getDeployerDir(name string) (string, error) {
+	projectDir, err := GetProjectDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(projectDir, name)
+	if err := EnsureDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// nodeModuleNix renders the body of a node's module shared by every backend: the user's
+// module with fetched hardware and disko configurations, the topology file and the deploy key
+func nodeModuleNix(modulePath string, node *HiveNode) (string, error) {
+	absModulePath, err := filepath.Abs(modulePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	imports := fmt.Sprintf("(import %s)", nixString(absModulePath))
+	for _, path := range []string{node.HardwareConfig, node.DiskoConfig} {
+		if path != "" {
+			imports += fmt.Sprintf(" (import %s)", nixString(path))
+		}
+	}
+	if node.VM != nil {
+		imports += " " + qemuVMModule
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "    imports = [ %s ]; # Import the user's module\n", imports)
+	body.WriteString("    environment.etc.\"inframan/topology.json\".text = builtins.toJSON inframan;\n")
+	if node.VM != nil {
+		body.WriteString(qemuVMModuleNix(node.VM))
+	}
+
+	// Authorize the deploy key for the deploy user, so a module without authorizedKeys cannot lock us out
+	publicKey, err := DeployPublicKey()
+	if err != nil {
+		return "", err
+	}
+	if publicKey != "" {
+		fmt.Fprintf(&body, "    users.users.%s.openssh.authorizedKeys.keys = [ %s ]; # Deploy key (SSH_KEY_PATH)\n",
+			nixString(node.deployUser()), nixString(publicKey))
+	}
+
+	// A deploy user other than root must be trusted by the Nix daemon to copy unsigned closures
+	if node.deployUser() != DefaultDeployUser {
+		fmt.Fprintf(&body, "    nix.settings.trusted-users = [ %s ]; # Deploy user (DEPLOY_USER)\n", nixString(node.deployUser()))
+	}
+
+	// Likewise trust the project CA when logins use certificates
+	if GetSSHCAKeyPath() != "" {
+		caPublicKey, err := SSHCAPublicKey()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&body, "    environment.etc.%s.text = %s; # Project CA (SSH_CA_KEY_PATH)\n",
+			nixString(trustedUserCAKeysFile), nixString(caPublicKey+"\n"))
+		fmt.Fprintf(&body, "    services.openssh.extraConfig = %s;\n", nixString("TrustedUserCAKeys /etc/"+trustedUserCAKeysFile+"\n"))
+	}
+	return body.String(), nil
+}
+
+// writeNodesFile writes nodes.nix, which evaluates every node like nixos-rebuild would,
+// with the topology passed as the inframan module argument
+func writeNodesFile(workDir, modulePath string, nodes []*HiveNode, topology *Topology) (string, error) {
+	if err := writeTopology(workDir, topology); err != nil {
+		return "", err
+	}
+
+	var nodeDefs strings.Builder
+	for _, node := range nodes {
+		body, err := nodeModuleNix(modulePath, node)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&nodeDefs, "\n  %s = evalNode %s %s ({ inframan, ... }: {\n%s  });\n",
+			nixString(node.Name), nixString(node.Name), nixString(node.Address), body)
+	}
+
+	content := fmt.Sprintf(`let
+  # Project, instances and selected Terraform outputs (see topology.json)
+  topology = builtins.fromJSON (builtins.readFile ./%s);
+
+  # Accept and ignore Colmena's deployment.* options, so machine modules written for
+  # Colmena evaluate with every backend
+  deploymentStub = { lib, ... }: {
+    options.deployment = lib.mkOption {
+      type = lib.types.submodule { freeformType = lib.types.attrsOf lib.types.anything; };
+      default = { };
+      description = "Colmena deployment options, ignored by this backend";
+    };
+  };
+
+  # Evaluate a node with the topology as the inframan module argument
+  evalNode = name: address: module: import <nixpkgs/nixos/lib/eval-config.nix> {
+    system = "x86_64-linux";
+    specialArgs.inframan = topology // { instance = name; inherit address; };
+    modules = [ deploymentStub module ];
+  };
+in {%s}
+`, TopologyFileName, nodeDefs.String())
+
+	path := filepath.Join(workDir, NodesFileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", NodesFileName, err)
+	}
+	return path, nil
+}
+
+// evalNodesFile evaluates a function of { nodes, ... } over nodes.nix
+func evalNodesFile(nodesPath, expr string) ([]byte, error) {
+	wrapped := fmt.Sprintf("(%s) { nodes = import %s; }", expr, nixString(nodesPath))
+	cmd := exec.Command("nix-instantiate", "--eval", "--strict", "--json", "--expr", wrapped)
+	cmd.Dir = filepath.Dir(nodesPath)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nix-instantiate --eval failed: %w", err)
+	}
+	return output, nil
+}
+
+// deployerSSHEnv returns the environment for backends that read SSH options from NIX_SSHOPTS
+func deployerSSHEnv() ([]string, error) {
+	sshOpts, err := SSHOptions()
+	if err != nil {
+		return nil, err
+	}
+	return append(os.Environ(), fmt.Sprintf("NIX_SSHOPTS=%s", strings.Join(sshOpts, " "))), nil
+}
+
+// EvalSystemPaths evaluates the system closure (toplevel) store path of every node.
+// Deployers of prebuilt closures (a manifest) return them without evaluating.
+func EvalSystemPaths(d Deployer) (map[string]string, error) {
+	if prebuilt, ok := d.(interface{ SystemPaths() map[string]string }); ok {
+		return prebuilt.SystemPaths(), nil
+	}
+	output, err := d.Eval("{ nodes, ... }: builtins.mapAttrs (name: node: node.config.system.build.toplevel.outPath) nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	var paths map[string]string
+	if err := json.Unmarshal(output, &paths); err != nil {
+		return nil, fmt.Errorf("failed to parse system paths: %w", err)
+	}
+	return paths, nil
+}
+
+// uploadSecretsOverSSH installs a node's secrets of an upload stage (pre-activation or
+// post-activation, "" for all) over SSH, for deployers without a key upload mechanism
+func uploadSecretsOverSSH(node *HiveNode, stage string) error {
+	for _, secret := range node.Secrets {
+		if stage != "" && secret.uploadStage() != stage {
+			continue
+		}
+		value, err := secret.Read()
+		if err != nil {
+			return err
+		}
+		path, user, group, permissions := secret.destination()
+		command := fmt.Sprintf("mkdir -p %s && install -m %s -o %s -g %s /dev/stdin %s",
+			shellQuote(filepath.Dir(path)), permissions, shellQuote(user), shellQuote(group), shellQuote(path))
+		if _, err := RunRemoteCommandInput(node.Address, command, value); err != nil {
+			return fmt.Errorf("failed to upload secret %q to %s: %w", secret.Name, node.Name, err)
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// findNodes returns the nodes with the given names, in that order
+func findNodes(nodes []*HiveNode, names []string) ([]*HiveNode, error) {
+	byName := make(map[string]*HiveNode, len(nodes))
+	for _, node := range nodes {
+		byName[node.Name] = node
+	}
+	found := make([]*HiveNode, len(names))
+	for i, name := range names {
+		node, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown node %q", name)
+		}
+		found[i] = node
+	}
+	return found, nil
+}
+
+// nodesFileDeployer holds what the nixos-rebuild and deploy-rs backends share: nodes.nix,
+// evaluated with nix-instantiate, and secrets uploaded over SSH
+type nodesFileDeployer struct {
+	workDir   string
+	nodesPath string
+	nodes     []*HiveNode
+}
+
+// generateNodes writes nodes.nix and remembers the nodes for later uploads
+func (d *nodesFileDeployer) generateNodes(modulePath string, nodes []*HiveNode, topology *Topology) error {
+	nodesPath, err := writeNodesFile(d.workDir, modulePath, nodes, topology)
+	if err != nil {
+		return err
+	}
+	d.nodesPath = nodesPath
+	d.nodes = nodes
+	return nil
+}
+
+// Eval evaluates an expression over the nodes of nodes.nix
+func (d *nodesFileDeployer) Eval(expr string) ([]byte, error) {
+	return evalNodesFile(d.nodesPath, expr)
+}
+
+// UploadKeys installs the secrets of the given nodes over SSH
+func (d *nodesFileDeployer) UploadKeys(nodeNames []string) error {
+	nodes, err := findNodes(d.nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := uploadSecretsOverSSH(node, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadStage installs the secrets of one upload stage on every node
+func (d *nodesFileDeployer) uploadStage(nodes []*HiveNode, stage string) error {
+	for _, node := range nodes {
+		if err := uploadSecretsOverSSH(node, stage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeploymentsFileName is the name of the per-project record of deployed system closures
+const DeploymentsFileName = "deployments.json"
+
+// NodeDeployment records the system closure last deployed to a node
+type NodeDeployment struct {
+	Node       string    `json:"node"`
+	Address    string    `json:"address"`
+	SystemPath string    `json:"systemPath"`
+	DeployedAt time.Time `json:"deployedAt"`
+	Operator   string    `json:"operator"`
+	Pending    bool      `json:"pending,omitempty"` // set as the boot default, not yet running
+}
+
+// HostStatus is the result of comparing a host's running system with its last deployment
+type HostStatus string
+
+const (
+	// HostInSync means the running system and the boot profile are the deployed closure
+	HostInSync HostStatus = "in sync"
+	// HostDiffers means the host runs a system inframan did not deploy (e.g. a manual nixos-rebuild)
+	HostDiffers HostStatus = "differs"
+	// HostMidRollback means the system profile and the running system disagree,
+	// e.g. after a rollback or an unfinished activation; the next boot changes the system
+	HostMidRollback HostStatus = "mid-rollback"
+	// HostPendingReboot means the deployed closure is the boot default but the host still
+	// runs an older system, e.g. after deploy --goal boot without --reboot
+	HostPendingReboot HostStatus = "pending reboot"
+	// HostNotDeployed means inframan has no record of deploying the node
+	HostNotDeployed HostStatus = "not deployed"
+	// HostUnreachable means the host's system could not be read
+	HostUnreachable HostStatus = "unreachable"
+)
+
+// HostDrift is the deployment state of one node
+type HostDrift struct {
+	Node     string
+	Address  string
+	Status   HostStatus
+	Deployed string // closure recorded at the last deploy
+	Current  string // /run/current-system
+	Profile  string // /nix/var/nix/profiles/system
+	Err      error
+}
+
+// Drifted reports whether the host no longer runs what inframan last deployed
+func (h *HostDrift) Drifted() bool {
+	return h.Status == HostDiffers || h.Status == HostMidRollback
+}
+
+// getDeploymentsPath returns the path of a project's deployment record
+// Structure: .inframan/<project-name>/deployments.json
+func getDeploymentsPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, DeploymentsFileName), nil
+}
+
+// LoadDeployments returns the last deployment of every node in a project, keyed by node name
+func LoadDeployments(projectName string) (map[string]*NodeDeployment, error) {
+	path, err := getDeploymentsPath(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make(map[string]*NodeDeployment)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return deployments, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployments: %w", err)
+	}
+	if err := json.Unmarshal(data, &deployments); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return deployments, nil
+}
+
+// RecordDeployments stores the system closures deployed to the given nodes,
+// keeping the records of nodes that were not part of this deploy. pending marks
+// closures that only become the running system at the next boot.
+func RecordDeployments(projectName string, nodes []*HiveNode, systemPaths map[string]string, pending bool) error {
+	deployments, err := LoadDeployments(projectName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	operator := currentOperator()
+	for _, node := range nodes {
+		systemPath, ok := systemPaths[node.Name]
+		if !ok {
+			continue
+		}
+		deployments[node.Name] = &NodeDeployment{
+			Node:       node.Name,
+			Address:    node.Address,
+			SystemPath: systemPath,
+			DeployedAt: now,
+			Operator:   operator,
+			Pending:    pending,
+		}
+	}
+
+	path, err := getDeploymentsPath(projectName)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(deployments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode deployments: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write deployments: %w", err)
+	}
+	return nil
+}
+
+// CheckHostDrift compares the running system of every node in a project with
+// the closure recorded at its last deploy
+func CheckHostDrift(projectName string) ([]*HostDrift, error) {
+	instances, err := GetInstancesForProject(projectName)
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := LoadDeployments(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*HostDrift
+	for _, node := range HiveNodesFromInstances(instances) {
+		result := &HostDrift{Node: node.Name, Address: node.Address}
+		if deployment, ok := deployments[node.Name]; ok {
+			result.Deployed = deployment.SystemPath
+		}
+
+		output, err := RunRemoteCommand(node.Address, "readlink -f /run/current-system; readlink -f /nix/var/nix/profiles/system")
+		lines := strings.Split(output, "\n")
+		switch {
+		case err != nil:
+			result.Status = HostUnreachable
+			result.Err = err
+		case len(lines) != 2:
+			result.Status = HostUnreachable
+			result.Err = fmt.Errorf("unexpected output from %s: %q", node.Address, output)
+		default:
+			result.Current = strings.TrimSpace(lines[0])
+			result.Profile = strings.TrimSpace(lines[1])
+			result.Status = classifyHost(result)
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Node < results[j].Node
+	})
+	return results, nil
+}
+
+// classifyHost derives a host's status from its deployed, running and profile closures
+func classifyHost(h *HostDrift) HostStatus {
+	switch {
+	case h.Deployed == "":
+		return HostNotDeployed
+	case h.Current != h.Profile && h.Profile == h.Deployed:
+		return HostPendingReboot
+	case h.Current != h.Profile:
+		return HostMidRollback
+	case h.Current != h.Deployed:
+		return HostDiffers
+	default:
+		return HostInSync
+	}
+}
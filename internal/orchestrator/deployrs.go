@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DeployRSFlakeFileName is the flake exposing deploy.nodes to deploy-rs
+const DeployRSFlakeFileName = "flake.nix"
+
+// GetDeployRSFlake returns the deploy-rs flake reference from DEPLOY_RS_FLAKE. The runner
+// pins it to the revision of its deploy binary, so activation matches the deploy tool.
+func GetDeployRSFlake() (string, error) {
+	ref := os.Getenv("DEPLOY_RS_FLAKE")
+	if ref == "" {
+		return "", fmt.Errorf("DEPLOY_RS_FLAKE is not set (the runner sets it to the deploy-rs revision of its deploy binary)")
+	}
+	return ref, nil
+}
+
+// DeployRSExecutor deploys with deploy-rs, whose magic rollback reverts a node that
+// cannot confirm the activation
+type DeployRSExecutor struct {
+	nodesFileDeployer
+}
+
+// NewDeployRSExecutor creates a new deploy-rs executor
+func NewDeployRSExecutor() (*DeployRSExecutor, error) {
+	workDir, err := getDeployerDir(DeployerDeployRS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deploy-rs directory: %w", err)
+	}
+	return &DeployRSExecutor{nodesFileDeployer{workDir: workDir}}, nil
+}
+
+// Name returns the deployer name
+func (d *DeployRSExecutor) Name() string {
+	return DeployerDeployRS
+}
+
+// Generate writes nodes.nix and a flake exposing the nodes as deploy.nodes
+func (d *DeployRSExecutor) Generate(modulePath string, nodes []*HiveNode, topology *Topology) error {
+	deployRSFlake, err := GetDeployRSFlake()
+	if err != nil {
+		return err
+	}
+	if err := d.generateNodes(modulePath, nodes, topology); err != nil {
+		return err
+	}
+
+	sshOptions, err := SSHOptions()
+	if err != nil {
+		return err
+	}
+	sshOptsNix := nixStringList(sshOptions)
+
+	var nodeDefs strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&nodeDefs, `
+      %s = {
+        hostname = %s;
+        sshUser = %s;
+        user = "root"; # sshUser other than root activates through sudo
+        sshOpts = %s;
+        remoteBuild = true; # Build on remote instance, not locally
+        profiles.system.path = activate nodes.%s;
+      };
+`, nixString(node.Name), nixString(node.Address), nixString(node.deployUser()), sshOptsNix, nixString(node.Name))
+	}
+
+	flake := fmt.Sprintf(`{
+  inputs.deploy-rs.url = %s; # DEPLOY_RS_FLAKE
+
+  outputs = { self, deploy-rs }: let
+    # Node configurations shared with the other deployers (reads <nixpkgs>, needs --impure)
+    nodes = import ./%s;
+    activate = node: deploy-rs.lib.${node.pkgs.system}.activate.nixos node;
+  in {
+    deploy.nodes = {%s    };
+  };
+}
+`, nixString(deployRSFlake), NodesFileName, nodeDefs.String())
+
+	if err := os.WriteFile(filepath.Join(d.workDir, DeployRSFlakeFileName), []byte(flake), 0644); err != nil {
+		return fmt.Errorf("failed to write deploy-rs flake: %w", err)
+	}
+	return nil
+}
+
+// Apply runs deploy on the given nodes. deploy-rs has no test goal; build and dry-activate
+// only build and check the activation (--dry-activate), boot activates on the next boot.
+func (d *DeployRSExecutor) Apply(goal string, nodeNames []string) error {
+	nodes, err := findNodes(d.nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--skip-checks"}
+	switch goal {
+	case "switch":
+	case "boot":
+		args = append(args, "--boot")
+	case "build", "dry-activate":
+		args = append(args, "--dry-activate")
+	default:
+		return fmt.Errorf("deploy-rs does not support the %s goal", goal)
+	}
+	args = append(args, "--targets")
+	for _, node := range nodes {
+		args = append(args, fmt.Sprintf("path:%s#%s", d.workDir, node.Name))
+	}
+	args = append(args, "--", "--impure")
+
+	activates := GoalChangesNodes(goal)
+	if activates {
+		if err := d.uploadStage(nodes, "pre-activation"); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("deploy", args...)
+	cmd.Dir = d.workDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	env, err := deployerSSHEnv()
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("deploy-rs %s failed: %w", goal, err)
+	}
+
+	if activates {
+		return d.uploadStage(nodes, "post-activation")
+	}
+	return nil
+}
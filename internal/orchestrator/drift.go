@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// DriftedResource is a resource whose real-world state no longer matches Terraform state
+type DriftedResource struct {
+	Address    string   `json:"address"`
+	Actions    []string `json:"actions"`    // "update" for changed resources, "delete" for vanished ones
+	Attributes []string `json:"attributes"` // changed top-level attributes
+}
+
+// DriftReport is the result of a refresh-only plan for one project
+type DriftReport struct {
+	ProjectName string             `json:"project"`
+	Resources   []*DriftedResource `json:"resources"`
+}
+
+// Drifted reports whether any resource has drifted
+func (r *DriftReport) Drifted() bool {
+	return len(r.Resources) > 0
+}
+
+// planJSON is the subset of terraform show -json output for a plan that drift detection reads
+type planJSON struct {
+	ResourceDrift []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions []string        `json:"actions"`
+			Before  json.RawMessage `json:"before"`
+			After   json.RawMessage `json:"after"`
+		} `json:"change"`
+	} `json:"resource_drift"`
+}
+
+// DetectDrift runs a refresh-only plan for a project and reports resources
+// changed outside of Terraform. The state itself is not modified.
+func DetectDrift(projectName string) (*DriftReport, error) {
+	if IsLocalProject(projectName) {
+		return nil, fmt.Errorf("project %q holds local VMs and has no Terraform state (check its hosts with --hosts)", projectName)
+	}
+	terraformDir, err := GetTerraformDirForProject(projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get terraform directory: %w", err)
+	}
+	if _, err := os.Stat(terraformDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("project %q does not exist", projectName)
+	}
+	if err := ensureInitInDir(terraformDir); err != nil {
+		return nil, fmt.Errorf("failed to initialize terraform for project %q: %w", projectName, err)
+	}
+
+	planFile, err := os.CreateTemp("", "inframan-drift-*.tfplan")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plan file: %w", err)
+	}
+	planFile.Close()
+	defer os.Remove(planFile.Name())
+
+	report := &DriftReport{ProjectName: projectName}
+
+	// -detailed-exitcode: 0 = no changes, 1 = error, 2 = changes present
+	cmd := exec.Command("terraform", "plan", "-refresh-only", "-detailed-exitcode", "-input=false", "-no-color", "-out="+planFile.Name())
+	cmd.Dir = terraformDir
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+			return nil, fmt.Errorf("terraform plan -refresh-only failed for project %q: %w", projectName, err)
+		}
+	} else {
+		return report, nil
+	}
+
+	cmd = exec.Command("terraform", "show", "-json", planFile.Name())
+	cmd.Dir = terraformDir
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("terraform show failed for project %q: %w", projectName, err)
+	}
+
+	var plan planJSON
+	if err := json.Unmarshal(output, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan for project %q: %w", projectName, err)
+	}
+
+	for _, drift := range plan.ResourceDrift {
+		resource := &DriftedResource{
+			Address: drift.Address,
+			Actions: drift.Change.Actions,
+		}
+		// Deleted resources have no "after"; listing every attribute would just be noise
+		if string(drift.Change.After) != "null" {
+			resource.Attributes = changedAttributes(drift.Change.Before, drift.Change.After)
+		}
+		report.Resources = append(report.Resources, resource)
+	}
+	sort.Slice(report.Resources, func(i, j int) bool {
+		return report.Resources[i].Address < report.Resources[j].Address
+	})
+
+	return report, nil
+}
@@ -0,0 +1,80 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// HardwareSubdir is the subdirectory for hardware configurations fetched from hosts
+	HardwareSubdir = "hardware"
+
+	// showHardwareConfigCommand prints a host's generated hardware configuration
+	showHardwareConfigCommand = "nixos-generate-config --show-hardware-config"
+)
+
+// GetHardwareDir returns the absolute path to the project's hardware subdirectory
+// Structure: .inframan/<project-name>/hardware/
+func GetHardwareDir() (string, error) {
+	projectDir, err := GetProjectDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(projectDir, HardwareSubdir), nil
+}
+
+// GetHardwareConfigPath returns the path of a node's hardware configuration
+// Structure: .inframan/<project-name>/hardware/<node>.nix
+func GetHardwareConfigPath(nodeName string) (string, error) {
+	dir, err := GetHardwareDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, nodeName+".nix"), nil
+}
+
+// AssignHardwareConfigs sets the hardware configuration of every node that has one stored
+func AssignHardwareConfigs(nodes []*HiveNode) error {
+	for _, node := range nodes {
+		path, err := GetHardwareConfigPath(node.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			node.HardwareConfig = path
+		}
+	}
+	return nil
+}
+
+// FetchHardwareConfig runs nixos-generate-config on a node and stores the result as
+// the node's hardware configuration. File systems are left out when disko manages them.
+func FetchHardwareConfig(node *HiveNode) error {
+	command := showHardwareConfigCommand
+	if node.DiskoConfig != "" {
+		command += " --no-filesystems"
+	}
+	output, err := RunRemoteCommand(node.Address, command)
+	if err != nil {
+		return fmt.Errorf("failed to fetch hardware configuration of %s: %w", node.Name, err)
+	}
+	if output == "" {
+		return fmt.Errorf("failed to fetch hardware configuration of %s: nixos-generate-config printed nothing", node.Name)
+	}
+
+	dir, err := GetHardwareDir()
+	if err != nil {
+		return err
+	}
+	if err := EnsureDir(dir); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, node.Name+".nix")
+	if err := os.WriteFile(path, []byte(output+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write hardware configuration of %s: %w", node.Name, err)
+	}
+
+	node.HardwareConfig = path
+	return nil
+}
@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHealthTimeout is how long a node may take to become healthy after a deploy
+	DefaultHealthTimeout = 2 * time.Minute
+
+	// healthPollInterval is the pause between health check attempts
+	healthPollInterval = 5 * time.Second
+
+	// healthProbeTimeout bounds a single HTTP or TCP probe
+	healthProbeTimeout = 10 * time.Second
+)
+
+// unitNamePattern matches systemd unit names, so they can be passed to a remote shell unquoted
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9@._:-]+$`)
+
+// HealthCheck describes how to tell that a node is healthy after a deploy.
+// Every configured check must pass; with none configured every node is healthy.
+type HealthCheck struct {
+	HTTP    string // URL that must answer 2xx; {address} and {node} are replaced per node
+	TCPPort int    // port that must accept connections
+	Unit    string // systemd unit that must be active
+	Command string // local shell command that must exit 0 (INFRAMAN_NODE, INFRAMAN_ADDRESS are set)
+	Timeout time.Duration
+}
+
+// Enabled reports whether any check is configured
+func (h *HealthCheck) Enabled() bool {
+	return h.HTTP != "" || h.TCPPort != 0 || h.Unit != "" || h.Command != ""
+}
+
+// Validate checks the health check configuration
+func (h *HealthCheck) Validate() error {
+	if h.TCPPort < 0 || h.TCPPort > 65535 {
+		return fmt.Errorf("invalid health check port %d", h.TCPPort)
+	}
+	if h.Unit != "" && !unitNamePattern.MatchString(h.Unit) {
+		return fmt.Errorf("invalid systemd unit name %q", h.Unit)
+	}
+	return nil
+}
+
+// Check runs every configured check against a node once
+func (h *HealthCheck) Check(node *HiveNode) error {
+	if h.HTTP != "" {
+		url := strings.NewReplacer("{address}", node.Address, "{node}", node.Name).Replace(h.HTTP)
+		client := &http.Client{Timeout: healthProbeTimeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return fmt.Errorf("GET %s failed: %w", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s returned %s", url, resp.Status)
+		}
+	}
+
+	if h.TCPPort != 0 {
+		address := net.JoinHostPort(node.Address, strconv.Itoa(h.TCPPort))
+		conn, err := net.DialTimeout("tcp", address, healthProbeTimeout)
+		if err != nil {
+			return fmt.Errorf("connect to %s failed: %w", address, err)
+		}
+		conn.Close()
+	}
+
+	if h.Unit != "" {
+		state, err := RunRemoteCommand(node.Address, fmt.Sprintf("systemctl is-active %s || true", h.Unit))
+		if err != nil {
+			return err
+		}
+		if state != "active" {
+			return fmt.Errorf("unit %s is %s", h.Unit, state)
+		}
+	}
+
+	if h.Command != "" {
+		cmd := exec.Command("sh", "-c", h.Command)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("INFRAMAN_NODE=%s", node.Name),
+			fmt.Sprintf("INFRAMAN_ADDRESS=%s", node.Address))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(output)); msg != "" {
+				return fmt.Errorf("health command failed: %w: %s", err, msg)
+			}
+			return fmt.Errorf("health command failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WaitHealthy repeats the checks until they pass or the timeout expires
+func (h *HealthCheck) WaitHealthy(node *HiveNode) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHealthTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := h.Check(node)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(healthPollInterval).After(deadline) {
+			return fmt.Errorf("%s not healthy after %s: %w", node.Name, timeout, err)
+		}
+		if err := Sleep(healthPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseBatchSize turns a batch size ("2" or "25%") into a number of nodes out of total.
+// An empty or zero size deploys every node in a single batch.
+func ParseBatchSize(value string, total int) (int, error) {
+	if value == "" || value == "0" {
+		return total, nil
+	}
+
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.Atoi(percent)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid batch size %q: percentage must be between 1%% and 100%%", value)
+		}
+		// Round up so small fleets still make progress
+		size := (total*p + 99) / 100
+		if size < 1 {
+			size = 1
+		}
+		return size, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid batch size %q: use a number of nodes or a percentage", value)
+	}
+	if size > total {
+		size = total
+	}
+	return size, nil
+}
@@ -0,0 +1,187 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HistoryFileName is the name of the per-project log of infra, deploy and destroy runs
+const HistoryFileName = "history.jsonl"
+
+const (
+	// HistorySuccess is the outcome of a run that completed
+	HistorySuccess = "success"
+	// HistoryFailure is the outcome of a run that returned an error
+	HistoryFailure = "failure"
+)
+
+// HistoryRecord is one infra, deploy or destroy run
+type HistoryRecord struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Project     string            `json:"project"`
+	Command     string            `json:"command"`
+	Operator    string            `json:"operator"`
+	Hostname    string            `json:"hostname"`
+	GitRevision string            `json:"gitRevision,omitempty"`
+	ConfigHash  string            `json:"configHash,omitempty"`
+	Instances   []string          `json:"instances,omitempty"`
+	Closures    map[string]string `json:"closures,omitempty"` // node -> deployed system closure
+	Pending     bool              `json:"pending,omitempty"`  // closures take effect at the next boot
+	Duration    float64           `json:"durationSeconds"`
+	Outcome     string            `json:"outcome"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// HistoryFilter selects history records; zero fields match everything
+type HistoryFilter struct {
+	Command  string
+	Operator string
+	Outcome  string
+	Instance string
+	Since    time.Time
+}
+
+// getHistoryPath returns the path of a project's history log
+// Structure: .inframan/<project-name>/history.jsonl
+func getHistoryPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, HistoryFileName), nil
+}
+
+// StartHistoryRecord begins the history record of a run of command in the current project
+func StartHistoryRecord(command string) *HistoryRecord {
+	return StartHistoryRecordForProject(GetProjectName(), command)
+}
+
+// StartHistoryRecordForProject begins the history record of a run of command in a named project
+func StartHistoryRecordForProject(projectName, command string) *HistoryRecord {
+	return &HistoryRecord{
+		Timestamp:   time.Now().UTC(),
+		Project:     projectName,
+		Command:     command,
+		Operator:    currentOperator(),
+		Hostname:    currentHostname(),
+		GitRevision: GitRevision(),
+	}
+}
+
+// Finish sets the duration and outcome of the run and appends the record to the project's history
+func (r *HistoryRecord) Finish(runErr error) error {
+	r.Duration = time.Since(r.Timestamp).Round(time.Millisecond).Seconds()
+	r.Outcome = HistorySuccess
+	if runErr != nil {
+		r.Outcome = HistoryFailure
+		r.Error = runErr.Error()
+	}
+
+	path, err := getHistoryPath(r.Project)
+	if err != nil {
+		return err
+	}
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	// A single write keeps each record on its own line
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// ReadHistory returns a project's history records, oldest first
+func ReadHistory(projectName string) ([]*HistoryRecord, error) {
+	path, err := getHistoryPath(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	var records []*HistoryRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record HistoryRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// Skip a truncated line (e.g. from a crash mid-write) rather than hide the rest
+			continue
+		}
+		records = append(records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return records, nil
+}
+
+// Matches reports whether the record is selected by the filter
+func (f *HistoryFilter) Matches(r *HistoryRecord) bool {
+	if f.Command != "" && r.Command != f.Command {
+		return false
+	}
+	if f.Operator != "" && r.Operator != f.Operator {
+		return false
+	}
+	if f.Outcome != "" && r.Outcome != f.Outcome {
+		return false
+	}
+	if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+		return false
+	}
+	if f.Instance != "" {
+		for _, instance := range r.Instances {
+			if instance == f.Instance {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// GitRevision returns the commit of the working tree, suffixed with "-dirty" when
+// there are uncommitted changes, or an empty string outside a git repository
+func GitRevision() string {
+	output, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	revision := strings.TrimSpace(string(output))
+
+	status, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+	if err == nil && len(strings.TrimSpace(string(status))) > 0 {
+		revision += "-dirty"
+	}
+	return revision
+}
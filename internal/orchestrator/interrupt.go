@@ -0,0 +1,35 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInterrupted is returned by waits cut short by SIGINT or SIGTERM
+var ErrInterrupted = errors.New("interrupted")
+
+// runContext is cancelled when inframan is interrupted
+var runContext = context.Background()
+
+// SetRunContext sets the context whose cancellation interrupts inframan's waits and polls
+func SetRunContext(ctx context.Context) {
+	runContext = ctx
+}
+
+// Interrupted reports whether the run has been interrupted
+func Interrupted() bool {
+	return runContext.Err() != nil
+}
+
+// Sleep pauses for d, returning ErrInterrupted as soon as the run is interrupted
+func Sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-runContext.Done():
+		return ErrInterrupted
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InventoryCacheFileName is the name of the per-project cache of discovered instances
+const InventoryCacheFileName = "instances.json"
+
+// inventoryCache is the on-disk format of the instance cache
+type inventoryCache struct {
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Instances []*InstanceInfo `json:"instances"`
+}
+
+// getInventoryCachePath returns the path of a project's instance cache
+// Structure: .inframan/<project-name>/instances.json
+func getInventoryCachePath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, InventoryCacheFileName), nil
+}
+
+// saveInventoryCache records the instances discovered from terraform output,
+// so that shell completion can list them without running terraform
+func saveInventoryCache(projectName string, instances []*InstanceInfo) error {
+	path, err := getInventoryCachePath(projectName)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(inventoryCache{UpdatedAt: time.Now(), Instances: instances}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode instance cache: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write instance cache: %w", err)
+	}
+	return nil
+}
+
+// GetCachedInstances returns the instances last discovered for every project,
+// without running terraform. Projects that were never queried are omitted.
+func GetCachedInstances() ([]*InstanceInfo, error) {
+	projects, err := GetAllProjectDirs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	var allInstances []*InstanceInfo
+	for _, project := range projects {
+		path, err := getInventoryCachePath(project)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var cache inventoryCache
+		if err := json.Unmarshal(data, &cache); err != nil {
+			// Ignore corrupt caches; they are rewritten on the next lookup
+			continue
+		}
+		allInstances = append(allInstances, cache.Instances...)
+	}
+
+	return allInstances, nil
+}
@@ -0,0 +1,474 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// LocalProjectSuffix names the project holding a project's local VMs: <project>-local
+	LocalProjectSuffix = "-local"
+
+	// LocalProvider is the provider of instances that are local QEMU VMs
+	LocalProvider = "local"
+
+	// LocalVMsFileName is the name of the local project's VM inventory
+	LocalVMsFileName = "vms.json"
+
+	// VMsSubdir is the subdirectory for VM disk images and console logs
+	VMsSubdir = "vms"
+
+	// VMSSHConfigFileName is a local project's ssh config, mapping the host aliases of its
+	// VMs to their forwarded ports
+	VMSSHConfigFileName = "vms.ssh_config"
+
+	// qemuVMModule is the NixOS module that turns a configuration into a QEMU VM,
+	// as nixos-rebuild build-vm does through virtualisation.vmVariant
+	qemuVMModule = "<nixpkgs/nixos/modules/virtualisation/qemu-vm.nix>"
+)
+
+// LocalVM is a QEMU VM started from a node's configuration
+type LocalVM struct {
+	Name       string    `json:"name"`
+	Address    string    `json:"address"` // host alias resolved by the VM ssh config
+	Port       int       `json:"port"`    // local port forwarded to the VM's port 22
+	PID        int       `json:"pid"`
+	DiskImage  string    `json:"diskImage"`
+	Log        string    `json:"log"`
+	SystemPath string    `json:"systemPath"`
+	Started    time.Time `json:"started"`
+}
+
+// Running reports whether the VM's QEMU process is still alive
+func (v *LocalVM) Running() bool {
+	return v.PID > 0 && syscall.Kill(v.PID, 0) == nil
+}
+
+// LocalVMsEnabled reports whether commands target the local VMs (INFRAMAN_LOCAL=1)
+// instead of the cloud instances
+func LocalVMsEnabled() bool {
+	return os.Getenv("INFRAMAN_LOCAL") == "1"
+}
+
+// EnableLocalVMs makes the rest of the run target the local VMs
+func EnableLocalVMs() {
+	os.Setenv("INFRAMAN_LOCAL", "1")
+}
+
+// getBaseProjectName returns the project name without the local VM suffix
+func getBaseProjectName() string {
+	return strings.TrimSuffix(GetProjectName(), LocalProjectSuffix)
+}
+
+// localVMAddress returns the host alias of a VM, which the VM ssh config resolves
+func localVMAddress(projectName, nodeName string) string {
+	return fmt.Sprintf("%s.%s.vm", nodeName, strings.TrimSuffix(projectName, LocalProjectSuffix))
+}
+
+// getLocalVMsPath returns the path of a project's VM inventory
+// Structure: .inframan/<project>-local/vms.json
+func getLocalVMsPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, LocalVMsFileName), nil
+}
+
+// IsLocalProject reports whether a project holds local VMs (<project>-local) rather than Terraform state
+func IsLocalProject(projectName string) bool {
+	path, err := getLocalVMsPath(projectName)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// LoadLocalVMs returns a local project's VMs by node name
+func LoadLocalVMs(projectName string) (map[string]*LocalVM, error) {
+	path, err := getLocalVMsPath(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	vms := make(map[string]*LocalVM)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vms, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", LocalVMsFileName, err)
+	}
+	if err := json.Unmarshal(data, &vms); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", LocalVMsFileName, err)
+	}
+	return vms, nil
+}
+
+// saveLocalVMs writes a local project's VM inventory and regenerates the VM ssh config
+func saveLocalVMs(projectName string, vms map[string]*LocalVM) error {
+	path, err := getLocalVMsPath(projectName)
+	if err != nil {
+		return err
+	}
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(vms, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", LocalVMsFileName, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", LocalVMsFileName, err)
+	}
+	return writeVMSSHConfig(projectName, vms)
+}
+
+// getLocalInstances returns the running VMs of a local project as instances
+func getLocalInstances(projectName string) ([]*InstanceInfo, error) {
+	vms, err := LoadLocalVMs(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*InstanceInfo
+	for name, vm := range vms {
+		if !vm.Running() {
+			continue
+		}
+		instances = append(instances, &InstanceInfo{
+			ProjectName:  projectName,
+			InstanceName: name,
+			PublicIP:     vm.Address,
+			Provider:     LocalProvider,
+		})
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no local VMs running for project %q (start them with 'inframan vm start')", projectName)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceName < instances[j].InstanceName
+	})
+	saveInventoryCache(projectName, instances)
+	return instances, nil
+}
+
+// getVMSSHConfigPath returns the path of a local project's VM ssh config
+// Structure: .inframan/<project>-local/vms.ssh_config
+func getVMSSHConfigPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, VMSSHConfigFileName), nil
+}
+
+// getVMSSHConfigPaths returns the VM ssh configs of every local project
+func getVMSSHConfigPaths() ([]string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(inframanDir, "*", VMSSHConfigFileName))
+}
+
+// vmSSHSettings returns the ssh settings that reach a VM through its forwarded port
+func vmSSHSettings(vm *LocalVM) [][2]string {
+	return [][2]string{
+		{"HostName", "127.0.0.1"},
+		{"Port", fmt.Sprint(vm.Port)},
+		// VM disks can be wiped, so their host keys are not remembered
+		{"UserKnownHostsFile", "/dev/null"},
+		{"LogLevel", "ERROR"},
+	}
+}
+
+// writeVMSSHConfig maps the host alias of every VM of a local project to its forwarded port
+func writeVMSSHConfig(projectName string, vms map[string]*LocalVM) error {
+	path, err := getVMSSHConfigPath(projectName)
+	if err != nil {
+		return err
+	}
+	if len(vms) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", VMSSHConfigFileName, err)
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(vms))
+	for name := range vms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var config strings.Builder
+	config.WriteString("# Generated by inframan for local VMs; do not edit\n")
+	for _, name := range names {
+		fmt.Fprintf(&config, "\nHost %s\n", vms[name].Address)
+		for _, setting := range vmSSHSettings(vms[name]) {
+			fmt.Fprintf(&config, "  %s %s\n", setting[0], setting[1])
+		}
+	}
+	if err := os.WriteFile(path, []byte(config.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", VMSSHConfigFileName, err)
+	}
+	return nil
+}
+
+// generateSSHConfig writes an ssh config to dir that resolves the VM host aliases of
+// every local project and includes SSH_CONFIG_PATH (or ~/.ssh/config) for every other host
+func generateSSHConfig(dir string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+
+	// ssh expands the pattern when it reads the config, so VMs started later in the run are found
+	var config strings.Builder
+	config.WriteString("# Generated by inframan for this run; do not edit\n")
+	fmt.Fprintf(&config, "Include %s\n", filepath.Join(inframanDir, "*", VMSSHConfigFileName))
+
+	// Keep the user's own configuration for every other host
+	baseConfig := GetSSHConfigPath()
+	if baseConfig == "" {
+		baseConfig = "~/.ssh/config"
+	} else if abs, err := filepath.Abs(baseConfig); err == nil {
+		baseConfig = abs
+	}
+	fmt.Fprintf(&config, "\nMatch all\n  Include %s\n", baseConfig)
+
+	path := filepath.Join(dir, "ssh_config")
+	if err := os.WriteFile(path, []byte(config.String()), 0600); err != nil {
+		return "", fmt.Errorf("failed to write ssh config: %w", err)
+	}
+	return path, nil
+}
+
+// LocalVMSSHOptions returns the ssh options that reach a local VM instance, for
+// interactive sessions that use SSH_CONFIG_PATH instead of the generated ssh config.
+// It returns nil for other instances.
+func LocalVMSSHOptions(info *InstanceInfo) ([]string, error) {
+	if info.Provider != LocalProvider {
+		return nil, nil
+	}
+	vms, err := LoadLocalVMs(info.ProjectName)
+	if err != nil {
+		return nil, err
+	}
+	vm, ok := vms[info.InstanceName]
+	if !ok {
+		return nil, fmt.Errorf("no local VM %q in project %q", info.InstanceName, info.ProjectName)
+	}
+
+	var opts []string
+	for _, setting := range vmSSHSettings(vm) {
+		opts = append(opts, "-o", fmt.Sprintf("%s=%s", setting[0], setting[1]))
+	}
+	return opts, nil
+}
+
+// HiveNodesForVMs returns hive nodes addressed by the VM host aliases, each with a new
+// disk image and forwarded SSH port for 'vm start'
+func HiveNodesForVMs(projectName string, names []string) ([]*HiveNode, error) {
+	vmDir, err := getLocalVMDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*HiveNode, len(names))
+	for i, name := range names {
+		port, err := freeLocalPort()
+		if err != nil {
+			return nil, err
+		}
+		address := localVMAddress(projectName, name)
+		nodes[i] = &HiveNode{
+			Name:    name,
+			Address: address,
+			User:    GetDeployUser(),
+			Tags:    []string{fmt.Sprintf("project-%s", projectName), fmt.Sprintf("provider-%s", LocalProvider)},
+			VM: &LocalVM{
+				Name:      name,
+				Address:   address,
+				Port:      port,
+				DiskImage: filepath.Join(vmDir, name+".qcow2"),
+				Log:       filepath.Join(vmDir, name+".log"),
+			},
+		}
+	}
+	return nodes, nil
+}
+
+// getLocalVMDir returns the directory of a local project's disk images and console logs
+// Structure: .inframan/<project>-local/vms/
+func getLocalVMDir(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, VMsSubdir), nil
+}
+
+// lookupLocalVM returns the registered VM of a local project's node, or nil if there is none
+func lookupLocalVM(projectName, name string) *LocalVM {
+	vms, err := LoadLocalVMs(projectName)
+	if err != nil {
+		return nil
+	}
+	return vms[name]
+}
+
+// qemuVMModuleNix returns the module settings that make a node's configuration the QEMU
+// VM it runs as, with the same disk image and forwarded SSH port, so the system deployed
+// to a running VM matches the one 'vm start' booted
+func qemuVMModuleNix(vm *LocalVM) string {
+	return fmt.Sprintf(`    virtualisation.diskImage = %s; # Local VM (inframan vm start)
+    virtualisation.graphics = false;
+    virtualisation.forwardPorts = [ { from = "host"; host.address = "127.0.0.1"; host.port = %d; guest.port = 22; } ];
+`, nixString(vm.DiskImage), vm.Port)
+}
+
+// GetBaseInstanceNames returns the instance names of the cloud project behind the local project
+func GetBaseInstanceNames() ([]string, error) {
+	instances, err := GetInstancesForProject(getBaseProjectName())
+	if err != nil {
+		return nil, err
+	}
+	return HiveNodeNames(HiveNodesFromInstances(instances)), nil
+}
+
+// EvalVMDerivations evaluates the derivation of each node's QEMU VM (nixos-rebuild build-vm)
+func EvalVMDerivations(d Deployer) (map[string]string, error) {
+	output, err := d.Eval("{ nodes, ... }: builtins.mapAttrs (name: node: node.config.system.build.vm.drvPath) nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	var drvs map[string]string
+	if err := json.Unmarshal(output, &drvs); err != nil {
+		return nil, fmt.Errorf("failed to parse VM derivations: %w", err)
+	}
+	return drvs, nil
+}
+
+// StartLocalVM boots a node's built VM in the background with the disk image and forwarded
+// SSH port of its configuration, and registers it as an instance of the local project
+func StartLocalVM(projectName string, node *HiveNode, vmPath string) (*LocalVM, error) {
+	if node.VM == nil {
+		return nil, fmt.Errorf("node %s is not a local VM", node.Name)
+	}
+	vms, err := LoadLocalVMs(projectName)
+	if err != nil {
+		return nil, err
+	}
+	if vm, ok := vms[node.Name]; ok && vm.Running() {
+		return nil, fmt.Errorf("VM %s is already running (pid %d)", node.Name, vm.PID)
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(vmPath, "bin", "run-*-vm"))
+	if err != nil || len(scripts) == 0 {
+		return nil, fmt.Errorf("no run script found in %s/bin", vmPath)
+	}
+
+	vmDir := filepath.Dir(node.VM.DiskImage)
+	if err := EnsureDir(vmDir); err != nil {
+		return nil, err
+	}
+
+	vm := *node.VM
+	vm.SystemPath = vmPath
+	vm.Started = time.Now().UTC()
+
+	logFile, err := os.OpenFile(vm.Log, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(scripts[0])
+	cmd.Dir = vmDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = os.Environ()
+	// Keep the VM running after inframan exits
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start VM %s: %w", node.Name, err)
+	}
+	vm.PID = cmd.Process.Pid
+	cmd.Process.Release()
+
+	vms[node.Name] = &vm
+	if err := saveLocalVMs(projectName, vms); err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
+
+// WaitForLocalVM waits until a VM accepts SSH logins, failing early if QEMU exits
+func WaitForLocalVM(vm *LocalVM, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !vm.Running() {
+			return fmt.Errorf("VM %s exited during boot (see %s)", vm.Name, vm.Log)
+		}
+		_, err := RunRemoteCommandFresh(vm.Address, "true", 5*time.Second)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("VM %s did not accept SSH logins within %s (see %s): %w", vm.Name, timeout, vm.Log, err)
+		}
+		if err := Sleep(2 * time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// StopLocalVM stops a VM and removes it from the local project's instances.
+// With wipe its disk image is deleted as well.
+func StopLocalVM(projectName, name string, wipe bool) error {
+	vms, err := LoadLocalVMs(projectName)
+	if err != nil {
+		return err
+	}
+	vm, ok := vms[name]
+	if !ok {
+		return fmt.Errorf("no local VM %q in project %q", name, projectName)
+	}
+
+	if vm.Running() {
+		if err := syscall.Kill(vm.PID, syscall.SIGTERM); err != nil {
+			return fmt.Errorf("failed to stop VM %s: %w", name, err)
+		}
+	}
+	if wipe {
+		if err := os.Remove(vm.DiskImage); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove disk image of %s: %w", name, err)
+		}
+	}
+
+	delete(vms, name)
+	return saveLocalVMs(projectName, vms)
+}
+
+// freeLocalPort returns a TCP port on localhost that is currently unused
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,192 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// LockFileName is the name of the per-project lock file
+	LockFileName = "inframan.lock"
+
+	// lockPollInterval is how often a waiting command retries the lock
+	lockPollInterval = time.Second
+)
+
+// ErrProjectLocked is returned when another inframan run holds the project lock
+var ErrProjectLocked = errors.New("project is locked by another inframan run")
+
+// LockInfo records who holds a project lock
+type LockInfo struct {
+	User      string    `json:"user"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// String describes the lock holder for error messages
+func (l *LockInfo) String() string {
+	return fmt.Sprintf("%q by %s@%s (pid %d) since %s", l.Command, l.User, l.Hostname, l.PID,
+		l.StartedAt.Format(time.RFC3339))
+}
+
+// ProjectLock is an exclusive lock on a project's .inframan directory.
+// It is backed by flock(2), so the kernel releases it if the holder dies;
+// the lock file itself only records who holds it.
+type ProjectLock struct {
+	file *os.File
+	info LockInfo
+}
+
+// getLockPath returns the lock file path for a project
+// Structure: .inframan/<project-name>/inframan.lock
+func getLockPath(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, LockFileName), nil
+}
+
+// AcquireProjectLock takes the lock for the current project, waiting up to timeout
+// for a conflicting run to finish. With a zero timeout it fails immediately,
+// reporting who holds the lock.
+func AcquireProjectLock(command string, timeout time.Duration) (*ProjectLock, error) {
+	return AcquireLockForProject(GetProjectName(), command, timeout)
+}
+
+// AcquireLockForProject takes the lock for a named project, for commands that
+// act on several projects at once
+func AcquireLockForProject(projectName, command string, timeout time.Duration) (*ProjectLock, error) {
+	path, err := getLockPath(projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lock path: %w", err)
+	}
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	announced := false
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		holder := readLockInfo(file)
+		if time.Now().After(deadline) {
+			file.Close()
+			if holder != nil {
+				return nil, fmt.Errorf("%w: held %s", ErrProjectLocked, holder)
+			}
+			return nil, ErrProjectLocked
+		}
+		if !announced {
+			if holder != nil {
+				fmt.Printf("Waiting for project lock held %s...\n", holder)
+			} else {
+				fmt.Println("Waiting for project lock...")
+			}
+			announced = true
+		}
+		if err := Sleep(lockPollInterval); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// A holder that exited without releasing leaves its info behind; the kernel
+	// already dropped its flock, so the lock is stale and safe to take over
+	if stale := readLockInfo(file); stale != nil {
+		fmt.Printf("Breaking stale project lock held %s\n", stale)
+	}
+
+	lock := &ProjectLock{
+		file: file,
+		info: LockInfo{
+			User:      currentOperator(),
+			Hostname:  currentHostname(),
+			PID:       os.Getpid(),
+			Command:   command,
+			StartedAt: time.Now(),
+		},
+	}
+	if err := lock.writeInfo(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Release records the lock as free and unlocks it
+func (l *ProjectLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	// Clear the holder info first so the next run does not report it as stale
+	l.file.Truncate(0)
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to release project lock: %w", err)
+	}
+	return nil
+}
+
+// writeInfo stores the holder info in the lock file
+func (l *ProjectLock) writeInfo() error {
+	data, err := json.MarshalIndent(l.info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %w", err)
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if _, err := l.file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// readLockInfo returns the holder info recorded in a lock file, or nil if it is empty
+func readLockInfo(file *os.File) *LockInfo {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil
+	}
+	return &info
+}
+
+// currentHostname returns the local hostname, or "unknown" if it cannot be determined
+func currentHostname() string {
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "unknown"
+}
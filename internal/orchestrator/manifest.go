@@ -0,0 +1,284 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestFileName is the default name of the manifest written by 'inframan build'
+	ManifestFileName = "manifest.json"
+
+	// GCRootsDirName is the directory next to a manifest holding a garbage collector root per
+	// node, so 'nix-collect-garbage' cannot delete the closures before they are deployed
+	GCRootsDirName = "gcroots"
+)
+
+// Manifest records the system closure built for every node of a project, so the
+// exact same closures can be deployed to it later
+type Manifest struct {
+	Project     string            `json:"project"`
+	BuiltAt     time.Time         `json:"builtAt"`
+	Operator    string            `json:"operator"`
+	GitRevision string            `json:"gitRevision,omitempty"`
+	ConfigHash  string            `json:"configHash,omitempty"`
+	Builder     string            `json:"builder,omitempty"`  // empty when built locally
+	SSHChecked  bool              `json:"sshChecked"`         // false when built with --skip-ssh-checks
+	Topology    *Topology         `json:"topology,omitempty"` // baked into every closure
+	Nodes       map[string]string `json:"nodes"`              // node -> system closure
+}
+
+// GetManifestPath returns the default manifest path of the current project
+// Structure: .inframan/<project-name>/manifest.json
+func GetManifestPath() (string, error) {
+	projectDir, err := GetProjectDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(projectDir, ManifestFileName), nil
+}
+
+// GetManifestGCRootsDir returns the directory holding the garbage collector roots of
+// the closures of a manifest
+func GetManifestGCRootsDir(manifestPath string) string {
+	return filepath.Join(filepath.Dir(manifestPath), GCRootsDirName)
+}
+
+// LoadManifest reads a manifest written by 'inframan build'
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if len(manifest.Nodes) == 0 {
+		return nil, fmt.Errorf("manifest %s lists no nodes", path)
+	}
+	for node, path := range manifest.Nodes {
+		if !strings.HasPrefix(path, storeDir) {
+			return nil, fmt.Errorf("manifest closure of %s is not a store path: %q", node, path)
+		}
+	}
+	return &manifest, nil
+}
+
+// Save writes the manifest to a file
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// EvalSystemDerivations evaluates the derivation of every node's system closure (toplevel)
+func EvalSystemDerivations(d Deployer) (map[string]string, error) {
+	output, err := d.Eval("{ nodes, ... }: builtins.mapAttrs (name: node: node.config.system.build.toplevel.drvPath) nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	var drvs map[string]string
+	if err := json.Unmarshal(output, &drvs); err != nil {
+		return nil, fmt.Errorf("failed to parse system derivations: %w", err)
+	}
+	return drvs, nil
+}
+
+// BuildSystems realises the nodes' system derivations into the local store and returns
+// each node's closure, registering it as the indirect garbage collector root
+// <gcRootsDir>/<node>. With a builder (a Nix remote builder such as ssh-ng://nix@builder)
+// every derivation is built there and its outputs copied back.
+func BuildSystems(drvs map[string]string, builder, gcRootsDir string) (map[string]string, error) {
+	nodes := make([]string, 0, len(drvs))
+	for node := range drvs {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	if err := EnsureDir(gcRootsDir); err != nil {
+		return nil, err
+	}
+
+	// One node at a time, so each closure gets a root named after its node
+	closures := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		root, err := filepath.Abs(filepath.Join(gcRootsDir, node))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		args := []string{"--realise", drvs[node], "--add-root", root, "--indirect"}
+		if builder != "" {
+			args = append(args, "--option", "builders", builder, "--max-jobs", "0")
+		}
+
+		cmd := exec.Command("nix-store", args...)
+		cmd.Stderr = os.Stderr
+		cmd.Env = os.Environ()
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to build the system closure of %s: %w", node, err)
+		}
+
+		// With --add-root nix-store prints the root rather than the output path it links to
+		path, err := os.Readlink(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the garbage collector root of %s: %w", node, err)
+		}
+		if !strings.HasPrefix(path, storeDir) {
+			return nil, fmt.Errorf("garbage collector root of %s does not link to a store path: %q", node, path)
+		}
+		closures[node] = path
+	}
+	return closures, nil
+}
+
+// PushClosure copies a closure from the local store to a node
+func PushClosure(node *HiveNode, path string) error {
+	cmd := exec.Command("nix-copy-closure", "--to", fmt.Sprintf("%s@%s", node.deployUser(), node.Address), path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	env, err := deployerSSHEnv()
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", path, node.Name, err)
+	}
+	return nil
+}
+
+// ActivateClosure activates a closure that is already on a host with a goal (switch, boot
+// or test); switch and boot also make it the system profile's new generation
+func ActivateClosure(address, path, goal string) error {
+	activate := fmt.Sprintf("%s/bin/switch-to-configuration %s", path, goal)
+	if goal != "test" {
+		activate = fmt.Sprintf("nix-env --profile %s --set %s && %s", SystemProfile, path, activate)
+	}
+	if _, err := RunRemoteCommand(address, activate); err != nil {
+		return fmt.Errorf("activation (%s) failed: %w", goal, err)
+	}
+	return nil
+}
+
+// ManifestDeployer deploys the prebuilt closures of a manifest: it copies each node's
+// closure from the local store and activates it, without evaluating or building anything
+type ManifestDeployer struct {
+	manifest *Manifest
+	nodes    []*HiveNode
+}
+
+// NewManifestDeployer creates a deployer for the closures of a manifest
+func NewManifestDeployer(manifest *Manifest) *ManifestDeployer {
+	return &ManifestDeployer{manifest: manifest}
+}
+
+// Name returns the deployer name
+func (m *ManifestDeployer) Name() string {
+	return "manifest"
+}
+
+// Generate checks that the manifest has a closure for every node and was built with the
+// topology the nodes have now, since the closures carry the topology they were built with
+func (m *ManifestDeployer) Generate(modulePath string, nodes []*HiveNode, topology *Topology) error {
+	if m.manifest.Topology == nil {
+		return fmt.Errorf("manifest records no topology; rebuild it with 'inframan build'")
+	}
+	if diffs := m.manifest.Topology.Differences(topology); len(diffs) > 0 {
+		return fmt.Errorf("the manifest's closures carry the topology of project %q, which differs from %q's (%s); build a manifest for %s instead",
+			m.manifest.Project, topology.Project, strings.Join(diffs, ", "), topology.Project)
+	}
+
+	var missing []string
+	for _, node := range nodes {
+		if _, ok := m.manifest.Nodes[node.Name]; !ok {
+			missing = append(missing, node.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("manifest has no closure for %s (built for project %q with %s)",
+			strings.Join(missing, ", "), m.manifest.Project, strings.Join(m.nodeNames(), ", "))
+	}
+	m.nodes = nodes
+	return nil
+}
+
+// nodeNames returns the sorted node names of the manifest
+func (m *ManifestDeployer) nodeNames() []string {
+	names := make([]string, 0, len(m.manifest.Nodes))
+	for name := range m.manifest.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Eval fails: a manifest holds built closures, not a configuration
+func (m *ManifestDeployer) Eval(expr string) ([]byte, error) {
+	return nil, fmt.Errorf("a manifest has no configuration to evaluate")
+}
+
+// SystemPaths returns the manifest's closures
+func (m *ManifestDeployer) SystemPaths() map[string]string {
+	return m.manifest.Nodes
+}
+
+// Apply copies the closures to the given nodes and activates them with a goal; build and
+// dry-activate only copy them
+func (m *ManifestDeployer) Apply(goal string, nodeNames []string) error {
+	nodes, err := findNodes(m.nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		path := m.manifest.Nodes[node.Name]
+		fmt.Printf("Copying %s to %s...\n", path, node.Name)
+		if err := PushClosure(node, path); err != nil {
+			return err
+		}
+		if !GoalChangesNodes(goal) {
+			continue
+		}
+
+		if err := uploadSecretsOverSSH(node, "pre-activation"); err != nil {
+			return err
+		}
+		fmt.Printf("Activating %s (%s)...\n", node.Name, goal)
+		if err := ActivateClosure(node.Address, path, goal); err != nil {
+			return fmt.Errorf("%s: %w", node.Name, err)
+		}
+		if err := uploadSecretsOverSSH(node, "post-activation"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadKeys installs the secrets of the given nodes over SSH
+func (m *ManifestDeployer) UploadKeys(nodeNames []string) error {
+	nodes, err := findNodes(m.nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := uploadSecretsOverSSH(node, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// stagingManifest returns a manifest built for a two-node staging project
+func stagingManifest() *Manifest {
+	return &Manifest{
+		Project: "staging",
+		Topology: &Topology{
+			Project:   "staging",
+			Instances: map[string]string{"web-1": "203.0.113.10", "web-2": "203.0.113.11"},
+			Outputs:   map[string]json.RawMessage{"db_endpoint": json.RawMessage(`{"host":"db.staging","port":5432}`)},
+		},
+		Nodes: map[string]string{
+			"web-1": "/nix/store/aaa-nixos-system-web-1",
+			"web-2": "/nix/store/bbb-nixos-system-web-2",
+		},
+	}
+}
+
+// stagingNodes returns the nodes of the staging project
+func stagingNodes() []*HiveNode {
+	return []*HiveNode{
+		{Name: "web-1", Address: "203.0.113.10"},
+		{Name: "web-2", Address: "203.0.113.11"},
+	}
+}
+
+// stagingTopology returns the staging project's topology as BuildTopology would now
+func stagingTopology() *Topology {
+	return &Topology{
+		Project:   "staging",
+		Instances: map[string]string{"web-2": "203.0.113.11", "web-1": "203.0.113.10"},
+		Outputs:   map[string]json.RawMessage{"db_endpoint": json.RawMessage(`{ "host": "db.staging", "port": 5432 }`)},
+	}
+}
+
+func TestManifestDeploysToProjectWithSameTopology(t *testing.T) {
+	deployer := NewManifestDeployer(stagingManifest())
+
+	if err := deployer.Generate("", stagingNodes(), stagingTopology()); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if got := deployer.SystemPaths()["web-2"]; got != "/nix/store/bbb-nixos-system-web-2" {
+		t.Fatalf("closure of web-2: %q", got)
+	}
+}
+
+func TestManifestRefusesDifferentTopology(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Topology)
+		want   string
+	}{
+		{
+			name:   "other project",
+			change: func(topology *Topology) { topology.Project = "prod" },
+			want:   "project staging -> prod",
+		},
+		{
+			name:   "replaced instance",
+			change: func(topology *Topology) { topology.Instances["web-1"] = "203.0.113.20" },
+			want:   "instance web-1 203.0.113.10 -> 203.0.113.20",
+		},
+		{
+			name:   "added instance",
+			change: func(topology *Topology) { topology.Instances["web-3"] = "203.0.113.12" },
+			want:   "instance web-3 added",
+		},
+		{
+			name: "changed output",
+			change: func(topology *Topology) {
+				topology.Outputs["db_endpoint"] = json.RawMessage(`{"host":"db.prod","port":5432}`)
+			},
+			want: "output db_endpoint changed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			topology := stagingTopology()
+			test.change(topology)
+
+			err := NewManifestDeployer(stagingManifest()).Generate("", stagingNodes(), topology)
+			if err == nil {
+				t.Fatalf("Generate succeeded, want an error mentioning %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("Generate: %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestManifestRefusesMissingTopology(t *testing.T) {
+	manifest := stagingManifest()
+	manifest.Topology = nil
+
+	if err := NewManifestDeployer(manifest).Generate("", stagingNodes(), stagingTopology()); err == nil {
+		t.Fatal("Generate succeeded for a manifest without topology")
+	}
+}
+
+func TestManifestRefusesNodeWithoutClosure(t *testing.T) {
+	manifest := stagingManifest()
+	delete(manifest.Nodes, "web-2")
+
+	err := NewManifestDeployer(manifest).Generate("", stagingNodes(), stagingTopology())
+	if err == nil || !strings.Contains(err.Error(), "no closure for web-2") {
+		t.Fatalf("Generate: %v, want an error about web-2's missing closure", err)
+	}
+}
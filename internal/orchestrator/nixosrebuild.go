@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// NixosRebuildExecutor deploys with nixos-rebuild --target-host, one node at a time,
+// building each system on its target
+type NixosRebuildExecutor struct {
+	nodesFileDeployer
+}
+
+// NewNixosRebuildExecutor creates a new nixos-rebuild executor
+func NewNixosRebuildExecutor() (*NixosRebuildExecutor, error) {
+	workDir, err := getDeployerDir(DeployerNixosRebuild)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nixos-rebuild directory: %w", err)
+	}
+	return &NixosRebuildExecutor{nodesFileDeployer{workDir: workDir}}, nil
+}
+
+// Name returns the deployer name
+func (n *NixosRebuildExecutor) Name() string {
+	return DeployerNixosRebuild
+}
+
+// Generate writes nodes.nix for the nodes
+func (n *NixosRebuildExecutor) Generate(modulePath string, nodes []*HiveNode, topology *Topology) error {
+	return n.generateNodes(modulePath, nodes, topology)
+}
+
+// Apply runs nixos-rebuild with a goal on each of the given nodes in turn
+func (n *NixosRebuildExecutor) Apply(goal string, nodeNames []string) error {
+	nodes, err := findNodes(n.nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+	env, err := deployerSSHEnv()
+	if err != nil {
+		return err
+	}
+	activates := GoalChangesNodes(goal)
+
+	for _, node := range nodes {
+		if activates {
+			if err := uploadSecretsOverSSH(node, "pre-activation"); err != nil {
+				return err
+			}
+		}
+
+		target := fmt.Sprintf("%s@%s", node.deployUser(), node.Address)
+		args := []string{goal, "--file", n.nodesPath, "--attr", node.Name, "--target-host", target, "--build-host", target}
+		if node.deployUser() != DefaultDeployUser {
+			args = append(args, "--use-remote-sudo")
+		}
+
+		fmt.Printf("nixos-rebuild %s on %s...\n", goal, node.Name)
+		cmd := exec.Command("nixos-rebuild", args...)
+		cmd.Dir = n.workDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.Env = env
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("nixos-rebuild %s failed on %s: %w", goal, node.Name, err)
+		}
+
+		if activates {
+			if err := uploadSecretsOverSSH(node, "post-activation"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
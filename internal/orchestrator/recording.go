@@ -0,0 +1,413 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iivel-inc/inframan/internal/term"
+)
+
+const (
+	// RecordingExt is the file extension of session recordings (asciicast v2)
+	RecordingExt = ".cast"
+
+	// asciicastVersion is the asciicast format version written by the recorder
+	asciicastVersion = 2
+)
+
+// GetRecordSessions reports whether SSH_RECORD_SESSIONS requests recording of every session
+func GetRecordSessions() bool {
+	switch strings.ToLower(os.Getenv("SSH_RECORD_SESSIONS")) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// RecordingHeader is the asciicast v2 header line, extended with audit fields.
+// Players ignore the fields they do not know about.
+type RecordingHeader struct {
+	Version      int               `json:"version"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	Timestamp    int64             `json:"timestamp"`
+	Duration     float64           `json:"duration,omitempty"`
+	Title        string            `json:"title,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	User         string            `json:"user"`
+	Operator     string            `json:"operator"`
+	Target       string            `json:"target"`
+	Address      string            `json:"address"`
+	EndTimestamp int64             `json:"end_timestamp,omitempty"`
+}
+
+// Recording is a session recording stored under a project
+type Recording struct {
+	Path   string
+	Header RecordingHeader
+}
+
+// Name returns the recording file name without extension
+func (r *Recording) Name() string {
+	return strings.TrimSuffix(filepath.Base(r.Path), RecordingExt)
+}
+
+// RecordingEvent is a single output event of a recording
+type RecordingEvent struct {
+	Time float64
+	Data string
+}
+
+// Recorder writes terminal output to an asciicast file
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	header  RecordingHeader
+	start   time.Time
+	pending []byte
+}
+
+// GetRecordingsDirForProject returns the recordings directory for a specific project
+// Structure: .inframan/<project-name>/recordings/
+func GetRecordingsDirForProject(projectName string) (string, error) {
+	inframanDir, err := GetInframanDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(inframanDir, projectName, RecordingsSubdir), nil
+}
+
+// NewRecorder creates a recording for a session with the given instance
+func NewRecorder(info *InstanceInfo, user string, size term.Size) (*Recorder, error) {
+	dir, err := GetRecordingsDirForProject(info.ProjectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recordings directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	start := time.Now()
+	name := fmt.Sprintf("%s-%s%s", start.UTC().Format("20060102T150405Z"),
+		strings.ReplaceAll(info.FullName(), "/", "_"), RecordingExt)
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &Recorder{
+		file:  file,
+		start: start,
+		header: RecordingHeader{
+			Version:   asciicastVersion,
+			Width:     int(size.Cols),
+			Height:    int(size.Rows),
+			Timestamp: start.Unix(),
+			Title:     fmt.Sprintf("%s@%s (%s)", user, info.FullName(), info.PublicIP),
+			Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+			User:      user,
+			Operator:  currentOperator(),
+			Target:    info.FullName(),
+			Address:   info.PublicIP,
+		},
+	}
+
+	if err := r.writeLine(r.header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the path of the recording file
+func (r *Recorder) Path() string {
+	return r.file.Name()
+}
+
+// Write records terminal output. Incomplete UTF-8 sequences are held back
+// until the rest of the character arrives, since events must be valid strings.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.pending, p...)
+	cut := len(data)
+	// Look back at most utf8.UTFMax-1 bytes for the start of an incomplete rune
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+
+	if cut > 0 {
+		if err := r.writeEvent(string(data[:cut])); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeEvent appends an output event
+func (r *Recorder) writeEvent(data string) error {
+	elapsed := time.Since(r.start).Seconds()
+	return r.writeLine([]interface{}{elapsed, "o", data})
+}
+
+// writeLine appends one JSON line to the recording
+func (r *Recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode recording event: %w", err)
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the recording and rewrites its header with the session end time
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) > 0 {
+		if err := r.writeEvent(string(r.pending)); err != nil {
+			r.file.Close()
+			return err
+		}
+		r.pending = nil
+	}
+
+	end := time.Now()
+	r.header.Duration = end.Sub(r.start).Seconds()
+	r.header.EndTimestamp = end.Unix()
+
+	if err := r.rewriteHeader(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// rewriteHeader replaces the first line of the recording with the current header
+func (r *Recorder) rewriteHeader() error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind recording: %w", err)
+	}
+	content, err := io.ReadAll(r.file)
+	if err != nil {
+		return fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	header, err := json.Marshal(r.header)
+	if err != nil {
+		return fmt.Errorf("failed to encode recording header: %w", err)
+	}
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		content = content[i:]
+	}
+	content = append(header, content...)
+
+	if err := r.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate recording: %w", err)
+	}
+	if _, err := r.file.WriteAt(content, 0); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// RunRecordedSession runs an interactive command under a pseudo-terminal,
+// relaying the user's terminal to it and recording everything it prints
+func RunRecordedSession(cmd *exec.Cmd, rec *Recorder) error {
+	master, slave, err := term.OpenPTY()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	stdinFd := int(os.Stdin.Fd())
+	if size, err := term.GetSize(stdinFd); err == nil {
+		term.SetSize(int(slave.Fd()), size)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	slave.Close()
+
+	state, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	defer term.Restore(stdinFd, state)
+
+	// Keep the pty size in sync with the user's terminal
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+	go func() {
+		for range resize {
+			if size, err := term.GetSize(stdinFd); err == nil {
+				term.SetSize(int(master.Fd()), size)
+			}
+		}
+	}()
+
+	go io.Copy(master, os.Stdin)
+
+	// Reading the master fails with EIO once the session has exited. The master
+	// must be drained until then, or the session blocks on a full pty and never exits.
+	out := &sessionOutput{rec: rec}
+	io.Copy(out, master)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("session exited: %w", err)
+	}
+	if out.err != nil {
+		return out.err
+	}
+	return nil
+}
+
+// sessionOutput relays session output to the user's terminal and the recorder. Once
+// either fails it stops writing there but keeps accepting output, so the session can
+// run to completion; the first recorder error is kept in err.
+type sessionOutput struct {
+	rec          *Recorder
+	err          error
+	stdoutFailed bool
+}
+
+func (s *sessionOutput) Write(p []byte) (int, error) {
+	if !s.stdoutFailed {
+		if _, err := os.Stdout.Write(p); err != nil {
+			s.stdoutFailed = true
+		}
+	}
+	if s.err == nil {
+		if _, err := s.rec.Write(p); err != nil {
+			s.err = err
+			fmt.Fprintf(os.Stderr, "\r\nWarning: recording stopped: %v\r\n", err)
+		}
+	}
+	return len(p), nil
+}
+
+// ListRecordings returns the recordings of a project, oldest first
+func ListRecordings(projectName string) ([]*Recording, error) {
+	dir, err := GetRecordingsDirForProject(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recordings directory: %w", err)
+	}
+
+	var recordings []*Recording
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), RecordingExt) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		header, err := readRecordingHeader(path)
+		if err != nil {
+			// Skip unreadable recordings
+			continue
+		}
+		recordings = append(recordings, &Recording{Path: path, Header: *header})
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Header.Timestamp < recordings[j].Header.Timestamp
+	})
+	return recordings, nil
+}
+
+// readRecordingHeader reads only the header line of a recording
+func readRecordingHeader(path string) (*RecordingHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var header RecordingHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("invalid recording header in %s: %w", path, err)
+	}
+	return &header, nil
+}
+
+// ReadRecording loads a recording and its output events
+func ReadRecording(path string) (*RecordingHeader, []RecordingEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return nil, nil, fmt.Errorf("recording %s is empty", path)
+	}
+	var header RecordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid recording header in %s: %w", path, err)
+	}
+
+	var events []RecordingEvent
+	for scanner.Scan() {
+		var raw []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			continue
+		}
+		elapsed, ok1 := raw[0].(float64)
+		kind, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if !ok1 || !ok2 || !ok3 || kind != "o" {
+			continue
+		}
+		events = append(events, RecordingEvent{Time: elapsed, Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	return &header, events, nil
+}
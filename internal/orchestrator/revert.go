@@ -0,0 +1,79 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RevertUnitName is the transient systemd unit that reverts an unconfirmed deploy
+	RevertUnitName = "inframan-revert"
+
+	// DefaultConfirmTimeout is how long a node has to accept a new SSH login after activation
+	DefaultConfirmTimeout = 3 * time.Minute
+
+	// confirmConnectTimeout bounds each fresh SSH connection attempt while confirming
+	confirmConnectTimeout = 10 * time.Second
+
+	// confirmRetryInterval is the pause between confirmation attempts
+	confirmRetryInterval = 5 * time.Second
+
+	// confirmMargin is kept between the end of confirmation attempts and the revert timer
+	// firing, so a late confirmation cannot race the revert
+	confirmMargin = 15 * time.Second
+)
+
+// ArmRevertTimer schedules, on the host itself, a switch back to a system generation
+// after timeout. Unless ConfirmDeploy disarms it, the host reverts even if inframan
+// can no longer reach it. The revert uses the currently running system's nix-env,
+// so it does not depend on the configuration being deployed.
+func ArmRevertTimer(address string, generation int, timeout time.Duration) error {
+	revert := fmt.Sprintf("$sys/sw/bin/nix-env --profile %[1]s --switch-generation %[2]d && %[1]s/bin/switch-to-configuration switch",
+		SystemProfile, generation)
+	command := fmt.Sprintf(`systemctl stop %[1]s.timer %[1]s.service 2>/dev/null; systemctl reset-failed %[1]s.service 2>/dev/null; `+
+		`sys=$(readlink -f /run/current-system) && systemd-run --quiet --unit=%[1]s --on-active=%[2]d --timer-property=AccuracySec=1s /bin/sh -c "%[3]s"`,
+		RevertUnitName, int(timeout.Seconds()), revert)
+
+	if _, err := RunRemoteCommand(address, command); err != nil {
+		return fmt.Errorf("failed to arm revert timer: %w", err)
+	}
+	return nil
+}
+
+// ConfirmDeploy proves that a host accepts new SSH logins after activation by opening
+// fresh connections (bypassing the shared ControlMaster, which may have survived a
+// broken sshd or firewall) until one succeeds, then disarms the revert timer.
+// It gives up before the timer armed at armedAt with timeout would fire.
+func ConfirmDeploy(address string, armedAt time.Time, timeout time.Duration) error {
+	deadline := armedAt.Add(timeout - confirmMargin)
+	command := fmt.Sprintf("systemctl stop %s.timer", RevertUnitName)
+
+	for {
+		_, err := RunRemoteCommandFresh(address, command, confirmConnectTimeout)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(confirmRetryInterval + confirmConnectTimeout).After(deadline) {
+			return fmt.Errorf("no new SSH login to %s within %s: %w", address, timeout, err)
+		}
+		if err := Sleep(confirmRetryInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// DisarmRevertTimer cancels a pending revert over the shared connection
+func DisarmRevertTimer(address string) error {
+	if _, err := RunRemoteCommand(address, fmt.Sprintf("systemctl stop %s.timer", RevertUnitName)); err != nil {
+		return fmt.Errorf("failed to disarm revert timer: %w", err)
+	}
+	return nil
+}
+
+// ValidateConfirmTimeout checks that a confirm timeout leaves room for confirmation attempts
+func ValidateConfirmTimeout(timeout time.Duration) error {
+	if minimum := confirmMargin + confirmConnectTimeout + confirmRetryInterval; timeout < minimum {
+		return fmt.Errorf("confirm timeout %s is too short, use at least %s", timeout, minimum)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package orchestrator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SystemProfile is the NixOS system profile whose generations rollback switches between
+const SystemProfile = "/nix/var/nix/profiles/system"
+
+// storePathPattern matches a top-level Nix store path, so it can be passed to a remote shell unquoted
+var storePathPattern = regexp.MustCompile(`^/nix/store/[a-z0-9]{32}-[A-Za-z0-9+._?=-]+$`)
+
+// generationLinkPattern matches the profile link name of a generation, e.g. "system-42-link"
+var generationLinkPattern = regexp.MustCompile(`^system-(\d+)-link$`)
+
+// RollbackTarget selects what a node is rolled back to. The zero value means
+// the generation before the current one.
+type RollbackTarget struct {
+	Generation int    // a specific system generation
+	Closure    string // a recorded system closure (store path), set as a new generation
+}
+
+// Validate checks that at most one target is set and that a closure is a store path
+func (t RollbackTarget) Validate() error {
+	if t.Generation != 0 && t.Closure != "" {
+		return fmt.Errorf("a generation and a closure cannot both be given")
+	}
+	if t.Generation < 0 {
+		return fmt.Errorf("invalid generation %d", t.Generation)
+	}
+	if t.Closure != "" && !storePathPattern.MatchString(t.Closure) {
+		return fmt.Errorf("invalid closure %q: expected a /nix/store path", t.Closure)
+	}
+	return nil
+}
+
+// String describes the target for display
+func (t RollbackTarget) String() string {
+	switch {
+	case t.Generation != 0:
+		return fmt.Sprintf("generation %d", t.Generation)
+	case t.Closure != "":
+		return t.Closure
+	default:
+		return "the previous generation"
+	}
+}
+
+// profileCommand returns the remote command that points the system profile at the target
+func (t RollbackTarget) profileCommand() string {
+	switch {
+	case t.Generation != 0:
+		return fmt.Sprintf("nix-env --profile %s --switch-generation %d", SystemProfile, t.Generation)
+	case t.Closure != "":
+		return fmt.Sprintf("{ test -e %[1]s || { echo '%[1]s is not in the host store' >&2; exit 1; }; } && nix-env --profile %[2]s --set %[1]s", t.Closure, SystemProfile)
+	default:
+		return fmt.Sprintf("nix-env --profile %s --rollback", SystemProfile)
+	}
+}
+
+// SystemGeneration is the system profile generation active on a host
+type SystemGeneration struct {
+	Number     int
+	SystemPath string // /run/current-system
+}
+
+// String describes the generation for display
+func (g *SystemGeneration) String() string {
+	if g == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d (%s)", g.Number, g.SystemPath)
+}
+
+// RollbackResult is the outcome of rolling back one node
+type RollbackResult struct {
+	Instance *InstanceInfo
+	Node     string
+	Before   *SystemGeneration
+	After    *SystemGeneration
+	Err      error
+}
+
+// ReadSystemGeneration returns the current system profile generation of a host
+func ReadSystemGeneration(address string) (*SystemGeneration, error) {
+	output, err := RunRemoteCommand(address, fmt.Sprintf("readlink %s; readlink -f /run/current-system", SystemProfile))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(output, "\n")
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected output from %s: %q", address, output)
+	}
+	match := generationLinkPattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if match == nil {
+		return nil, fmt.Errorf("unexpected system profile link on %s: %q", address, lines[0])
+	}
+	number, _ := strconv.Atoi(match[1])
+	return &SystemGeneration{Number: number, SystemPath: strings.TrimSpace(lines[1])}, nil
+}
+
+// RollbackNode switches a host to the target system generation and activates it
+func RollbackNode(address string, target RollbackTarget) error {
+	command := fmt.Sprintf("%s && %s/bin/switch-to-configuration switch", target.profileCommand(), SystemProfile)
+	if _, err := RunRemoteCommand(address, command); err != nil {
+		return fmt.Errorf("rollback to %s failed: %w", target, err)
+	}
+	return nil
+}
+
+// PreviousDeployedClosure returns the system closure deployed to a node before its
+// most recent deploy, from the project's history
+func PreviousDeployedClosure(projectName, node string) (string, error) {
+	records, err := ReadHistory(projectName)
+	if err != nil {
+		return "", err
+	}
+
+	latest := ""
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		closure := record.Closures[node]
+		if record.Command != "deploy" || record.Outcome != HistorySuccess || closure == "" {
+			continue
+		}
+		if latest == "" {
+			latest = closure
+		} else if closure != latest {
+			return closure, nil
+		}
+	}
+	return "", fmt.Errorf("no earlier deployed closure of %s/%s in history", projectName, node)
+}
+
+// RollbackInstances rolls back every instance in parallel to its target (targets[i] for
+// instances[i]), recording each node's generation before and after. Results are in the
+// order of instances.
+func RollbackInstances(instances []*InstanceInfo, targets []RollbackTarget) []*RollbackResult {
+	nodes := HiveNodesFromInstances(instances)
+	results := make([]*RollbackResult, len(instances))
+
+	var wg sync.WaitGroup
+	for i := range instances {
+		results[i] = &RollbackResult{Instance: instances[i], Node: nodes[i].Name}
+		wg.Add(1)
+		go func(result *RollbackResult, address string, target RollbackTarget) {
+			defer wg.Done()
+
+			before, err := ReadSystemGeneration(address)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Before = before
+
+			result.Err = RollbackNode(address, target)
+
+			// Read the generation even after a failed switch: the profile may already have moved
+			if after, err := ReadSystemGeneration(address); err == nil {
+				result.After = after
+			} else if result.Err == nil {
+				result.Err = err
+			}
+		}(results[i], nodes[i].Address, targets[i])
+	}
+	wg.Wait()
+
+	return results
+}
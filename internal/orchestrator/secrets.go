@@ -0,0 +1,267 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// secretNamePattern matches secret names, which become file names under destDir
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// permissionsPattern matches octal file modes such as "0400"
+var permissionsPattern = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// Secret is a value shipped to hosts as a Colmena deployment key. Exactly one
+// source (File, Env, Age or Sops) is set; the value is read on the machine
+// running inframan at upload time and never written to the hive.
+type Secret struct {
+	Name string `json:"-"`
+
+	// Sources
+	File        string `json:"file,omitempty"`        // plain local file
+	Env         string `json:"env,omitempty"`         // environment variable of the inframan process
+	Age         string `json:"age,omitempty"`         // age-encrypted file, decrypted with AgeIdentity
+	AgeIdentity string `json:"ageIdentity,omitempty"` // age identity file for Age
+	Sops        string `json:"sops,omitempty"`        // sops-encrypted file
+	SopsKey     string `json:"sopsKey,omitempty"`     // top-level key to extract from a sops file (default: whole file)
+
+	// Destination
+	DestDir     string   `json:"destDir,omitempty"`     // default: /run/keys
+	User        string   `json:"user,omitempty"`        // default: root
+	Group       string   `json:"group,omitempty"`       // default: root
+	Permissions string   `json:"permissions,omitempty"` // default: 0600
+	UploadAt    string   `json:"uploadAt,omitempty"`    // pre-activation (default) or post-activation
+	Nodes       []string `json:"nodes,omitempty"`       // nodes receiving the secret (default: all)
+}
+
+// GetSecretsConfigPath returns the path of the project's secrets declaration from SECRETS_JSON
+func GetSecretsConfigPath() string {
+	return os.Getenv("SECRETS_JSON")
+}
+
+// LoadSecrets reads and validates the secrets declared in SECRETS_JSON, sorted by name.
+// It returns nil if no secrets are configured.
+func LoadSecrets() ([]*Secret, error) {
+	path := GetSecretsConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets config: %w", err)
+	}
+	var declared map[string]*Secret
+	if err := json.Unmarshal(data, &declared); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets config %s: %w", path, err)
+	}
+
+	secrets := make([]*Secret, 0, len(declared))
+	for name, secret := range declared {
+		secret.Name = name
+		if err := secret.validate(); err != nil {
+			return nil, fmt.Errorf("secret %q: %w", name, err)
+		}
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// validate checks the secret's declaration and that its source is available
+func (s *Secret) validate() error {
+	if !secretNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid name: use letters, digits, '.', '_' and '-'")
+	}
+
+	sources := 0
+	for _, source := range []string{s.File, s.Env, s.Age, s.Sops} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of file, env, age or sops must be set")
+	}
+
+	if s.Permissions != "" && !permissionsPattern.MatchString(s.Permissions) {
+		return fmt.Errorf("invalid permissions %q: use an octal mode such as 0400", s.Permissions)
+	}
+	if s.UploadAt != "" && s.UploadAt != "pre-activation" && s.UploadAt != "post-activation" {
+		return fmt.Errorf("invalid uploadAt %q: use pre-activation or post-activation", s.UploadAt)
+	}
+	if s.DestDir != "" && !filepath.IsAbs(s.DestDir) {
+		return fmt.Errorf("destDir %q must be an absolute path", s.DestDir)
+	}
+
+	switch {
+	case s.Env != "":
+		if _, ok := os.LookupEnv(s.Env); !ok {
+			return fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+	case s.Age != "":
+		if s.AgeIdentity == "" {
+			return fmt.Errorf("age secrets need an ageIdentity file")
+		}
+		if err := resolveSecretPath(&s.Age); err != nil {
+			return err
+		}
+		if err := resolveSecretPath(&s.AgeIdentity); err != nil {
+			return err
+		}
+	case s.Sops != "":
+		if err := resolveSecretPath(&s.Sops); err != nil {
+			return err
+		}
+	default:
+		if err := resolveSecretPath(&s.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSecretPath makes a local path absolute (relative to the working directory) and checks it exists
+func resolveSecretPath(path *string) error {
+	abs, err := filepath.Abs(*path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", *path, err)
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return fmt.Errorf("%s: %w", abs, err)
+	}
+	*path = abs
+	return nil
+}
+
+// AppliesTo reports whether a node receives the secret
+func (s *Secret) AppliesTo(node string) bool {
+	if len(s.Nodes) == 0 {
+		return true
+	}
+	for _, n := range s.Nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// DeploymentKey returns the secret as a Colmena deployment.keys entry. Values are
+// referenced by keyFile or produced by keyCommand, so they never enter the hive or the Nix store.
+func (s *Secret) DeploymentKey() map[string]interface{} {
+	key := map[string]interface{}{}
+	switch {
+	case s.File != "":
+		key["keyFile"] = s.File
+	case s.Env != "":
+		key["keyCommand"] = []string{"printenv", s.Env}
+	case s.Age != "":
+		key["keyCommand"] = []string{"age", "--decrypt", "--identity", s.AgeIdentity, s.Age}
+	case s.Sops != "":
+		command := []string{"sops", "--decrypt"}
+		if s.SopsKey != "" {
+			command = append(command, "--extract", fmt.Sprintf("[%q]", s.SopsKey))
+		}
+		key["keyCommand"] = append(command, s.Sops)
+	}
+
+	if s.DestDir != "" {
+		key["destDir"] = s.DestDir
+	}
+	if s.User != "" {
+		key["user"] = s.User
+	}
+	if s.Group != "" {
+		key["group"] = s.Group
+	}
+	if s.Permissions != "" {
+		key["permissions"] = s.Permissions
+	}
+	if s.UploadAt != "" {
+		key["uploadAt"] = s.UploadAt
+	}
+	return key
+}
+
+// Read produces the secret's value locally, the way Colmena reads a keyFile or runs a keyCommand
+func (s *Secret) Read() ([]byte, error) {
+	key := s.DeploymentKey()
+	if path, ok := key["keyFile"].(string); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %q: %w", s.Name, err)
+		}
+		return data, nil
+	}
+
+	command := key["keyCommand"].([]string)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stderr = os.Stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to produce secret %q with %s: %w", s.Name, command[0], err)
+	}
+	return data, nil
+}
+
+// destination returns where the secret is installed on a host, with Colmena's defaults applied
+func (s *Secret) destination() (path, user, group, permissions string) {
+	dir, user, group, permissions := s.DestDir, s.User, s.Group, s.Permissions
+	if dir == "" {
+		dir = "/run/keys"
+	}
+	if user == "" {
+		user = "root"
+	}
+	if group == "" {
+		group = "root"
+	}
+	if permissions == "" {
+		permissions = "0600"
+	}
+	return filepath.Join(dir, s.Name), user, group, permissions
+}
+
+// uploadStage returns when the secret is uploaded relative to activation
+func (s *Secret) uploadStage() string {
+	if s.UploadAt == "" {
+		return "pre-activation"
+	}
+	return s.UploadAt
+}
+
+// AssignSecrets attaches to every node the secrets it receives, and checks that
+// secrets restricted to nodes only name nodes of the project
+func AssignSecrets(nodes []*HiveNode, secrets []*Secret) error {
+	known := make(map[string]bool)
+	for _, node := range nodes {
+		known[node.Name] = true
+	}
+	for _, secret := range secrets {
+		for _, name := range secret.Nodes {
+			if !known[name] {
+				return fmt.Errorf("secret %q names unknown node %q (nodes: %s)", secret.Name, name, strings.Join(HiveNodeNames(nodes), ", "))
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		node.Secrets = nil
+		for _, secret := range secrets {
+			if secret.AppliesTo(node.Name) {
+				node.Secrets = append(node.Secrets, secret)
+			}
+		}
+	}
+	return nil
+}
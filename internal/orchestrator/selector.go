@@ -0,0 +1,109 @@
+package orchestrator
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SelectInstances returns the instances matched by a selector, sorted by full name.
+//
+// A selector is a comma-separated list of terms, each of which is one of:
+//
+//	project            all instances of a project
+//	project/instance   a single instance
+//	project/web-*      instances whose name matches a glob
+//	*/web-*            the same across all projects
+func SelectInstances(selector string) ([]*InstanceInfo, error) {
+	seen := make(map[string]bool)
+	var selected []*InstanceInfo
+
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		projectPattern, instancePattern := part, ""
+		if i := strings.Index(part, "/"); i >= 0 {
+			projectPattern, instancePattern = part[:i], part[i+1:]
+		}
+
+		projects, err := matchProjects(projectPattern)
+		if err != nil {
+			return nil, err
+		}
+		isGlob := isProjectGlob(projectPattern)
+
+		matched := 0
+		for _, project := range projects {
+			instances, err := GetInstancesForProject(project)
+			if err != nil {
+				if isGlob {
+					// Skip projects with errors, like GetAllInstances
+					continue
+				}
+				return nil, err
+			}
+			for _, inst := range instances {
+				if instancePattern != "" {
+					ok, err := path.Match(instancePattern, inst.InstanceName)
+					if err != nil {
+						return nil, fmt.Errorf("invalid selector %q: %w", part, err)
+					}
+					if !ok {
+						continue
+					}
+				}
+				matched++
+				if !seen[inst.FullName()] {
+					seen[inst.FullName()] = true
+					selected = append(selected, inst)
+				}
+			}
+		}
+
+		if matched == 0 {
+			return nil, fmt.Errorf("selector %q did not match any instance", part)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].FullName() < selected[j].FullName()
+	})
+	return selected, nil
+}
+
+// matchProjects returns the project names matching a project name or glob
+func matchProjects(pattern string) ([]string, error) {
+	if !isProjectGlob(pattern) {
+		return []string{pattern}, nil
+	}
+
+	projects, err := GetAllProjectDirs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	var matched []string
+	for _, project := range projects {
+		ok, err := path.Match(pattern, project)
+		if err != nil {
+			return nil, fmt.Errorf("invalid project pattern %q: %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, project)
+		}
+	}
+	return matched, nil
+}
+
+// isProjectGlob reports whether a project pattern contains glob characters
+func isProjectGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
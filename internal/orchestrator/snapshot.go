@@ -0,0 +1,295 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSnapshotRetention is the number of snapshots kept per project
+	// when STATE_SNAPSHOT_RETENTION is not set
+	DefaultSnapshotRetention = 20
+
+	// snapshotStateExt and snapshotMetaExt are the file extensions of a snapshot's state and metadata
+	snapshotStateExt = ".tfstate"
+	snapshotMetaExt  = ".json"
+)
+
+// Snapshot is a copy of a project's Terraform state taken before a change
+type Snapshot struct {
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Operation  string    `json:"operation"`
+	ConfigHash string    `json:"configHash"`
+	Serial     int64     `json:"serial"`
+	Lineage    string    `json:"lineage"`
+	Resources  int       `json:"resources"`
+}
+
+// StateDiff lists the resource instances that differ between two states
+type StateDiff struct {
+	Added   []string
+	Removed []string
+	Changed map[string][]string // address -> changed top-level attributes
+}
+
+// Empty reports whether the states have the same resources and attributes
+func (d *StateDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// GetSnapshotRetention returns how many snapshots to keep from STATE_SNAPSHOT_RETENTION
+func GetSnapshotRetention() (int, error) {
+	value := os.Getenv("STATE_SNAPSHOT_RETENTION")
+	if value == "" {
+		return DefaultSnapshotRetention, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid STATE_SNAPSHOT_RETENTION %q: must be a positive number", value)
+	}
+	return n, nil
+}
+
+// GetSnapshotsDir returns the absolute path to the project's snapshots subdirectory
+// Structure: .inframan/<project-name>/snapshots/
+func GetSnapshotsDir() (string, error) {
+	projectDir, err := GetProjectDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(projectDir, SnapshotsSubdir), nil
+}
+
+// HashFile returns the hex-encoded SHA-256 of a file, or empty string if it does not exist
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// TakeStateSnapshot pulls the current state and stores it with the config hash.
+// It returns nil if the project has no state yet.
+func (t *TerraformExecutor) TakeStateSnapshot(operation string) (*Snapshot, error) {
+	data, err := t.StatePull()
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	state, err := ParseState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	configHash, err := HashFile(filepath.Join(t.workDir, ConfigFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := GetSnapshotsDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	now := time.Now().UTC()
+	id := fmt.Sprintf("%s-%s", now.Format("20060102T150405.000Z"), operation)
+	// Snapshots taken within the same millisecond get a counter
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, id+snapshotMetaExt)); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%s-%d", now.Format("20060102T150405.000Z"), operation, n)
+	}
+	snapshot := &Snapshot{
+		ID:         id,
+		Timestamp:  now,
+		Operation:  operation,
+		ConfigHash: configHash,
+		Serial:     state.Serial,
+		Lineage:    state.Lineage,
+		Resources:  len(state.Instances()),
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, snapshot.ID+snapshotStateExt), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	meta, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshot.ID+snapshotMetaExt), meta, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot metadata: %w", err)
+	}
+
+	if err := pruneSnapshots(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the project's snapshots, newest first
+func ListSnapshots() ([]*Snapshot, error) {
+	dir, err := GetSnapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotMetaExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.After(snapshots[j].Timestamp)
+	})
+	return snapshots, nil
+}
+
+// GetSnapshotStatePath returns the path of a snapshot's state file, checking that it exists
+func GetSnapshotStatePath(id string) (string, error) {
+	dir, err := GetSnapshotsDir()
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid snapshot id %q", id)
+	}
+	path := filepath.Join(dir, id+snapshotStateExt)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("snapshot %q not found", id)
+	}
+	return path, nil
+}
+
+// ReadSnapshotState returns the raw state stored in a snapshot
+func ReadSnapshotState(id string) ([]byte, error) {
+	path, err := GetSnapshotStatePath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// LoadSnapshotState reads and parses the state stored in a snapshot
+func LoadSnapshotState(id string) (*TerraformState, error) {
+	data, err := ReadSnapshotState(id)
+	if err != nil {
+		return nil, err
+	}
+	return ParseState(data)
+}
+
+// pruneSnapshots removes the oldest snapshots beyond the retention limit
+func pruneSnapshots() error {
+	retention, err := GetSnapshotRetention()
+	if err != nil {
+		return err
+	}
+	snapshots, err := ListSnapshots()
+	if err != nil || len(snapshots) <= retention {
+		return err
+	}
+
+	dir, err := GetSnapshotsDir()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots[retention:] {
+		os.Remove(filepath.Join(dir, snapshot.ID+snapshotStateExt))
+		os.Remove(filepath.Join(dir, snapshot.ID+snapshotMetaExt))
+	}
+	return nil
+}
+
+// DiffStates compares the resource instances of two states
+func DiffStates(from, to *TerraformState) *StateDiff {
+	diff := &StateDiff{Changed: make(map[string][]string)}
+	fromInstances := from.Instances()
+	toInstances := to.Instances()
+
+	for address, toAttrs := range toInstances {
+		fromAttrs, ok := fromInstances[address]
+		if !ok {
+			diff.Added = append(diff.Added, address)
+			continue
+		}
+		if changed := changedAttributes(fromAttrs, toAttrs); len(changed) > 0 {
+			diff.Changed[address] = changed
+		}
+	}
+	for address := range fromInstances {
+		if _, ok := toInstances[address]; !ok {
+			diff.Removed = append(diff.Removed, address)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// changedAttributes returns the sorted names of top-level attributes that differ
+func changedAttributes(from, to json.RawMessage) []string {
+	var fromMap, toMap map[string]interface{}
+	json.Unmarshal(from, &fromMap)
+	json.Unmarshal(to, &toMap)
+
+	var changed []string
+	for key, toValue := range toMap {
+		if fromValue, ok := fromMap[key]; !ok || !reflect.DeepEqual(fromValue, toValue) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range fromMap {
+		if _, ok := toMap[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,248 @@
+package orchestrator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sshControlPersist is how long (in seconds) an idle ControlMaster stays up.
+	// Masters are torn down explicitly at the end of a run; this only bounds
+	// how long they can linger if inframan is killed before it can clean up.
+	sshControlPersist = "300"
+
+	// sshMuxPlaceholderHost is passed to `ssh -O exit`, which needs a host
+	// argument even though the control socket already identifies the master
+	sshMuxPlaceholderHost = "inframan-mux"
+)
+
+// sshMux holds the per-run directory shared by every SSH-using step: it holds the
+// control sockets and the generated ssh config
+var sshMux struct {
+	mu   sync.Mutex
+	dir  string
+	err  error
+	done bool
+
+	config     string
+	configErr  error
+	configDone bool
+}
+
+// SSHMultiplexEnabled reports whether SSH connection multiplexing is enabled.
+// It is on by default and can be disabled with SSH_MULTIPLEX=0.
+func SSHMultiplexEnabled() bool {
+	switch strings.ToLower(os.Getenv("SSH_MULTIPLEX")) {
+	case "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+// sshRunDir returns the directory holding the ControlMaster sockets and the generated
+// ssh config for this run, creating it on first use
+func sshRunDir() (string, error) {
+	sshMux.mu.Lock()
+	defer sshMux.mu.Unlock()
+
+	if sshMux.done {
+		return sshMux.dir, sshMux.err
+	}
+	sshMux.done = true
+
+	// Unix socket paths are limited to ~104 bytes, so prefer a short base
+	// directory over $TMPDIR (which is very long on macOS)
+	base := os.TempDir()
+	if info, err := os.Stat("/tmp"); err == nil && info.IsDir() {
+		base = "/tmp"
+	}
+
+	dir, err := os.MkdirTemp(base, "inframan-ssh-")
+	if err != nil {
+		sshMux.err = fmt.Errorf("failed to create ssh control directory: %w", err)
+		return "", sshMux.err
+	}
+	sshMux.dir = dir
+	return dir, nil
+}
+
+// sshConfigFile returns the ssh config file every non-interactive ssh invocation of the
+// run uses: once local VMs exist, a config generated in the run's ssh directory (see
+// generateSSHConfig), otherwise SSH_CONFIG_PATH, or empty string if unset
+func sshConfigFile() (string, error) {
+	vmConfigs, err := getVMSSHConfigPaths()
+	if err != nil {
+		return "", err
+	}
+	if len(vmConfigs) == 0 {
+		return GetSSHConfigPath(), nil
+	}
+
+	dir, err := sshRunDir()
+	if err != nil {
+		return "", err
+	}
+
+	sshMux.mu.Lock()
+	defer sshMux.mu.Unlock()
+	if !sshMux.configDone {
+		sshMux.configDone = true
+		sshMux.config, sshMux.configErr = generateSSHConfig(dir)
+	}
+	return sshMux.config, sshMux.configErr
+}
+
+// sshMultiplexOptions returns the ssh options that share one ControlMaster per host
+// for the duration of the current run, or nil if multiplexing is disabled
+func sshMultiplexOptions() ([]string, error) {
+	if !SSHMultiplexEnabled() {
+		return nil, nil
+	}
+
+	dir, err := sshRunDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// %C is a hash of the connection parameters, which keeps the path short
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", fmt.Sprintf("ControlPath=%s", filepath.Join(dir, "%C")),
+		"-o", fmt.Sprintf("ControlPersist=%s", sshControlPersist),
+	}, nil
+}
+
+// SSHOptions returns the ssh arguments used by every non-interactive SSH step of a run
+// (Colmena deployment, nix-copy-closure, remote commands). It includes the configured
+// SSH config file, the key or CA-issued certificate, and the shared ControlMaster options when multiplexing is enabled.
+func SSHOptions() ([]string, error) {
+	opts, err := sshBaseOptions()
+	if err != nil {
+		return nil, err
+	}
+	muxOpts, err := sshMultiplexOptions()
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, muxOpts...), nil
+}
+
+// sshBaseOptions returns the SSH config file, identity and host key options
+func sshBaseOptions() ([]string, error) {
+	var opts []string
+	sshConfigPath, err := sshConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if sshConfigPath != "" {
+		opts = append(opts, "-F", sshConfigPath)
+	}
+	keyPath, certPath, err := SSHIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if keyPath != "" {
+		opts = append(opts, "-i", keyPath)
+	}
+	if certPath != "" {
+		opts = append(opts, "-o", fmt.Sprintf("CertificateFile=%s", certPath))
+	}
+	// Add convenience option for new hosts
+	opts = append(opts, "-o", "StrictHostKeyChecking=accept-new")
+	return opts, nil
+}
+
+// StopSSHMultiplexing closes every ControlMaster opened during this run and removes
+// the run's ssh directory. It is safe to call when multiplexing was never used.
+func StopSSHMultiplexing() {
+	sshMux.mu.Lock()
+	defer sshMux.mu.Unlock()
+
+	if sshMux.dir == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(sshMux.dir)
+	if err == nil {
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSocket == 0 {
+				continue
+			}
+			socket := filepath.Join(sshMux.dir, entry.Name())
+			cmd := exec.Command("ssh", "-o", fmt.Sprintf("ControlPath=%s", socket), "-O", "exit", sshMuxPlaceholderHost)
+			// Errors only mean the master is already gone
+			_ = cmd.Run()
+		}
+	}
+
+	os.RemoveAll(sshMux.dir)
+	sshMux.dir = ""
+	sshMux.done = false
+	sshMux.config = ""
+	sshMux.configErr = nil
+	sshMux.configDone = false
+}
+
+// RunRemoteCommand runs a command on a host as root, logging in as the deploy user
+// with the run's SSH options, and returns its trimmed standard output
+func RunRemoteCommand(address, command string) (string, error) {
+	sshOpts, err := SSHOptions()
+	if err != nil {
+		return "", err
+	}
+	return runRemote(sshOpts, GetDeployUser(), address, command, nil)
+}
+
+// RunRemoteCommandInput runs a command on a host as root, feeding input to its standard input
+func RunRemoteCommandInput(address, command string, input []byte) (string, error) {
+	sshOpts, err := SSHOptions()
+	if err != nil {
+		return "", err
+	}
+	return runRemote(sshOpts, GetDeployUser(), address, command, bytes.NewReader(input))
+}
+
+// RunRemoteCommandFresh runs a command over a new SSH connection that bypasses any
+// shared ControlMaster, proving that the host still accepts new logins
+func RunRemoteCommandFresh(address, command string, connectTimeout time.Duration) (string, error) {
+	sshOpts, err := sshBaseOptions()
+	if err != nil {
+		return "", err
+	}
+	sshOpts = append(sshOpts,
+		"-o", "ControlMaster=no",
+		"-o", "ControlPath=none",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", int(connectTimeout.Seconds())))
+	return runRemote(sshOpts, GetDeployUser(), address, command, nil)
+}
+
+// runRemote logs in to a host as user with the given ssh options and runs a command as root,
+// through sudo unless user is root, with optional standard input
+func runRemote(sshOpts []string, user, address, command string, stdin io.Reader) (string, error) {
+	if user != DefaultDeployUser {
+		command = "sudo -n -- sh -c " + shellQuote(command)
+	}
+	args := append(sshOpts, "-o", "BatchMode=yes", fmt.Sprintf("%s@%s", user, address), command)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = stdin
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("ssh to %s failed: %w: %s", address, err, msg)
+		}
+		return "", fmt.Errorf("ssh to %s failed: %w", address, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
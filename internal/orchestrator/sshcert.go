@@ -0,0 +1,303 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSSHCertTTL is the certificate lifetime used when SSH_CERT_TTL is not set
+	DefaultSSHCertTTL = time.Hour
+
+	// DefaultSSHCertPrincipal is the principal used when SSH_CERT_PRINCIPALS is not set
+	DefaultSSHCertPrincipal = "root"
+
+	// sshCertKeyName is the file name of the ephemeral key pair signed by the CA
+	sshCertKeyName = "id_ed25519"
+
+	// sshCertMetaName is the file name of the cached certificate metadata
+	sshCertMetaName = "cert.json"
+
+	// trustedUserCAKeysFile is where hosts keep the project CA's public key, relative to /etc
+	trustedUserCAKeysFile = "ssh/inframan_user_ca.pub"
+
+	// sshCertRenewMargin renews certificates this long before they expire,
+	// so a certificate does not run out in the middle of a deploy
+	sshCertRenewMargin = 5 * time.Minute
+
+	// sshCertBackdate allows for clock skew between this machine and the hosts
+	sshCertBackdate = "-5m"
+)
+
+// SSHCertificate describes a short-lived user certificate issued from the project CA
+type SSHCertificate struct {
+	KeyPath     string    `json:"keyPath"`
+	CertPath    string    `json:"certPath"`
+	CAKeyPath   string    `json:"caKeyPath"`
+	Identity    string    `json:"identity"`
+	Principals  []string  `json:"principals"`
+	IssuedAt    time.Time `json:"issuedAt"`
+	ValidBefore time.Time `json:"validBefore"`
+}
+
+// Valid reports whether the certificate can still be used for at least the renewal margin
+func (c *SSHCertificate) Valid() bool {
+	return time.Now().Add(sshCertRenewMargin).Before(c.ValidBefore)
+}
+
+// GetSSHCAKeyPath returns the SSH CA private key path from environment, or empty string if not set
+func GetSSHCAKeyPath() string {
+	return os.Getenv("SSH_CA_KEY_PATH")
+}
+
+// GetSSHCertPrincipals returns the principals to embed in issued certificates
+// from SSH_CERT_PRINCIPALS (comma-separated), defaulting to "root"
+func GetSSHCertPrincipals() []string {
+	var principals []string
+	for _, p := range strings.Split(os.Getenv("SSH_CERT_PRINCIPALS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			principals = append(principals, p)
+		}
+	}
+	if len(principals) == 0 {
+		return []string{DefaultSSHCertPrincipal}
+	}
+	return principals
+}
+
+// GetSSHCertTTL returns the certificate lifetime from SSH_CERT_TTL (e.g. "30m", "8h")
+func GetSSHCertTTL() (time.Duration, error) {
+	value := os.Getenv("SSH_CERT_TTL")
+	if value == "" {
+		return DefaultSSHCertTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SSH_CERT_TTL %q: %w", value, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid SSH_CERT_TTL %q: must be positive", value)
+	}
+	return ttl, nil
+}
+
+// GetSSHDir returns the absolute path to the project's ssh subdirectory
+// Structure: .inframan/<project-name>/ssh/
+func GetSSHDir() (string, error) {
+	projectDir, err := GetProjectDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(projectDir, SSHSubdir), nil
+}
+
+// LoadSSHCertificate reads the cached certificate metadata for the project,
+// returning nil if no certificate has been issued yet
+func LoadSSHCertificate() (*SSHCertificate, error) {
+	sshDir, err := GetSSHDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(sshDir, sshCertMetaName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate metadata: %w", err)
+	}
+
+	var cert SSHCertificate
+	if err := json.Unmarshal(data, &cert); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate metadata: %w", err)
+	}
+
+	// The files may have been removed independently of the metadata
+	if _, err := os.Stat(cert.CertPath); err != nil {
+		return nil, nil
+	}
+	return &cert, nil
+}
+
+// EnsureSSHCertificate returns a valid certificate for the project, issuing a new one
+// if the cached certificate is missing, expiring, or was issued with a different CA,
+// principals or lifetime. It returns nil if no CA is configured.
+func EnsureSSHCertificate() (*SSHCertificate, error) {
+	caKeyPath := GetSSHCAKeyPath()
+	if caKeyPath == "" {
+		return nil, nil
+	}
+	ttl, err := GetSSHCertTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := LoadSSHCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.Valid() && cached.CAKeyPath == caKeyPath &&
+		strings.Join(cached.Principals, ",") == strings.Join(GetSSHCertPrincipals(), ",") &&
+		cached.ValidBefore.Sub(cached.IssuedAt) == ttl {
+		return cached, nil
+	}
+
+	return IssueSSHCertificate()
+}
+
+// IssueSSHCertificate generates a fresh key pair and signs it with the project CA
+func IssueSSHCertificate() (*SSHCertificate, error) {
+	caKeyPath := GetSSHCAKeyPath()
+	if caKeyPath == "" {
+		return nil, fmt.Errorf("SSH_CA_KEY_PATH environment variable is not set")
+	}
+	if _, err := os.Stat(caKeyPath); err != nil {
+		return nil, fmt.Errorf("SSH CA key does not exist: %s", caKeyPath)
+	}
+
+	ttl, err := GetSSHCertTTL()
+	if err != nil {
+		return nil, err
+	}
+	principals := GetSSHCertPrincipals()
+
+	sshDir, err := GetSSHDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ssh directory: %w", err)
+	}
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", sshDir, err)
+	}
+
+	keyPath := filepath.Join(sshDir, sshCertKeyName)
+	certPath := keyPath + "-cert.pub"
+
+	// ssh-keygen refuses to overwrite existing keys
+	for _, path := range []string{keyPath, keyPath + ".pub", certPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove old key %s: %w", path, err)
+		}
+	}
+
+	identity := fmt.Sprintf("inframan:%s@%s", currentOperator(), GetProjectName())
+	genCmd := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", identity, "-f", keyPath)
+	if output, err := genCmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed to generate key: %w\n%s", err, strings.TrimSpace(string(output)))
+	}
+
+	issuedAt := time.Now()
+	validity := fmt.Sprintf("%s:+%ds", sshCertBackdate, int64(ttl.Seconds()))
+	signCmd := exec.Command("ssh-keygen", "-q",
+		"-s", caKeyPath,
+		"-I", identity,
+		"-n", strings.Join(principals, ","),
+		"-V", validity,
+		keyPath+".pub")
+	// The CA key may be passphrase-protected; ssh-keygen prompts on the terminal
+	var signOutput strings.Builder
+	signCmd.Stdin = os.Stdin
+	signCmd.Stdout = &signOutput
+	signCmd.Stderr = os.Stderr
+	if err := signCmd.Run(); err != nil {
+		if output := strings.TrimSpace(signOutput.String()); output != "" {
+			return nil, fmt.Errorf("ssh-keygen failed to sign certificate: %w\n%s", err, output)
+		}
+		return nil, fmt.Errorf("ssh-keygen failed to sign certificate: %w", err)
+	}
+
+	cert := &SSHCertificate{
+		KeyPath:     keyPath,
+		CertPath:    certPath,
+		CAKeyPath:   caKeyPath,
+		Identity:    identity,
+		Principals:  principals,
+		IssuedAt:    issuedAt,
+		ValidBefore: issuedAt.Add(ttl),
+	}
+
+	data, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, sshCertMetaName), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write certificate metadata: %w", err)
+	}
+
+	return cert, nil
+}
+
+// SSHCAPublicKey returns the public half of the project CA key
+func SSHCAPublicKey() (string, error) {
+	caKeyPath := GetSSHCAKeyPath()
+	if caKeyPath == "" {
+		return "", fmt.Errorf("SSH_CA_KEY_PATH environment variable is not set")
+	}
+	return readPublicKey(caKeyPath)
+}
+
+// readPublicKey returns the public half of an SSH private key, read from <key>.pub
+// if it exists and derived with ssh-keygen otherwise
+func readPublicKey(keyPath string) (string, error) {
+	if data, err := os.ReadFile(keyPath + ".pub"); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	cmd := exec.Command("ssh-keygen", "-y", "-f", keyPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to derive public key from %s: %w", keyPath, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// TrustedUserCAKeysSnippet returns a NixOS module that makes sshd accept
+// certificates signed by the project CA
+func TrustedUserCAKeysSnippet() (string, error) {
+	publicKey, err := SSHCAPublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`# Trust SSH user certificates issued by inframan for project %s
+{
+  environment.etc.%s.text = ''
+    %s
+  '';
+  services.openssh.extraConfig = ''
+    TrustedUserCAKeys /etc/%s
+  '';
+}
+`, GetProjectName(), nixString(trustedUserCAKeysFile), publicKey, trustedUserCAKeysFile), nil
+}
+
+// SSHIdentity returns the private key and, when a CA is configured, the certificate
+// to authenticate with. Both are empty if neither a CA nor SSH_KEY_PATH is configured.
+func SSHIdentity() (keyPath, certPath string, err error) {
+	cert, err := EnsureSSHCertificate()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to obtain SSH certificate: %w", err)
+	}
+	if cert != nil {
+		return cert.KeyPath, cert.CertPath, nil
+	}
+	return GetSSHKeyPath(), "", nil
+}
+
+// currentOperator returns the name of the local user running inframan
+func currentOperator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
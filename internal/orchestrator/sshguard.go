@@ -0,0 +1,168 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sshAccessExpr evaluates, for every node, the settings that decide whether inframan
+// can still log in as the node's deploy user over SSH after activation. It takes an
+// attribute set mapping node names to deploy users.
+const sshAccessExpr = `users: { nodes, ... }: builtins.mapAttrs (name: node:
+  let
+    ssh = node.config.services.openssh;
+    fw = node.config.networking.firewall;
+    userName = users.${name};
+    user = node.config.users.users.${userName} or null;
+    inWheel = user != null && builtins.elem "wheel" user.extraGroups;
+    # sudo and sudo-rs share these options
+    passwordless = sudo: sudo.enable && ((inWheel && !sudo.wheelNeedsPassword)
+      || builtins.any (rule: builtins.elem userName (rule.users or [])
+        && builtins.any (c: builtins.isAttrs c && builtins.elem "NOPASSWD" (c.options or [])) rule.commands)
+        sudo.extraRules);
+  in {
+    sshd = ssh.enable;
+    ports = ssh.ports;
+    openFirewall = ssh.openFirewall;
+    firewall = fw.enable;
+    allowedTCPPorts = fw.allowedTCPPorts
+      ++ builtins.concatMap (i: i.allowedTCPPorts) (builtins.attrValues fw.interfaces);
+    allowedTCPPortRanges = fw.allowedTCPPortRanges
+      ++ builtins.concatMap (i: i.allowedTCPPortRanges) (builtins.attrValues fw.interfaces);
+    permitRootLogin = ssh.settings.PermitRootLogin or "prohibit-password";
+    trustedUserCA = builtins.length (builtins.split "TrustedUserCAKeys" ssh.extraConfig) > 1
+      || (ssh.settings.TrustedUserCAKeys or null) != null;
+    user = userName;
+    userDeclared = user != null && (user.isNormalUser || user.isSystemUser
+      || (user.uid or null) != null && user.uid < 1000);
+    userKeys = if user == null then 0
+      else builtins.length user.openssh.authorizedKeys.keys + builtins.length user.openssh.authorizedKeys.keyFiles;
+    passwordlessSudo = passwordless node.config.security.sudo
+      || passwordless (node.config.security.sudo-rs or { enable = false; });
+  }) nodes`
+
+// NodeSSHAccess is the evaluated SSH configuration of a node
+type NodeSSHAccess struct {
+	SSHD                 bool  `json:"sshd"`
+	Ports                []int `json:"ports"`
+	OpenFirewall         bool  `json:"openFirewall"`
+	Firewall             bool  `json:"firewall"`
+	AllowedTCPPorts      []int `json:"allowedTCPPorts"`
+	AllowedTCPPortRanges []struct {
+		From int `json:"from"`
+		To   int `json:"to"`
+	} `json:"allowedTCPPortRanges"`
+	PermitRootLogin  string `json:"permitRootLogin"`
+	TrustedUserCA    bool   `json:"trustedUserCA"`
+	User             string `json:"user"`
+	UserDeclared     bool   `json:"userDeclared"`
+	UserKeys         int    `json:"userKeys"`
+	PasswordlessSudo bool   `json:"passwordlessSudo"`
+}
+
+// DeployPublicKey returns the public half of the deploy key (SSH_KEY_PATH),
+// or an empty string if no deploy key is configured
+func DeployPublicKey() (string, error) {
+	keyPath := GetSSHKeyPath()
+	if keyPath == "" {
+		return "", nil
+	}
+	return readPublicKey(keyPath)
+}
+
+// EvalSSHAccess evaluates the SSH configuration every node will have after activation
+func EvalSSHAccess(d Deployer, nodes []*HiveNode) (map[string]*NodeSSHAccess, error) {
+	var users strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&users, " %s = %s;", nixString(node.Name), nixString(node.deployUser()))
+	}
+	output, err := d.Eval(fmt.Sprintf("(%s) {%s }", sshAccessExpr, users.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	var access map[string]*NodeSSHAccess
+	if err := json.Unmarshal(output, &access); err != nil {
+		return nil, fmt.Errorf("failed to parse SSH configuration: %w", err)
+	}
+	return access, nil
+}
+
+// Problems lists the reasons inframan could not log in as the deploy user on port 22
+// after activation, or could not act as root once logged in. certPrincipals are the
+// principals of issued certificates when logins use a CA, and nil otherwise.
+func (a *NodeSSHAccess) Problems(certPrincipals []string) []string {
+	var problems []string
+
+	if !a.SSHD {
+		problems = append(problems, "sshd is disabled (services.openssh.enable = false)")
+	} else if !containsPort(a.Ports, 22) {
+		problems = append(problems, fmt.Sprintf("sshd does not listen on port 22 (services.openssh.ports = %v)", a.Ports))
+	}
+
+	if a.Firewall && !(a.OpenFirewall && containsPort(a.Ports, 22)) && !a.firewallAllows(22) {
+		problems = append(problems, "port 22 is closed in the firewall (add it to networking.firewall.allowedTCPPorts)")
+	}
+
+	if a.User == DefaultDeployUser {
+		switch a.PermitRootLogin {
+		case "no", "forced-commands-only":
+			problems = append(problems, fmt.Sprintf("root cannot log in (PermitRootLogin = %q)", a.PermitRootLogin))
+		}
+	} else {
+		if !a.UserDeclared {
+			problems = append(problems, fmt.Sprintf("deploy user %q is not declared (users.users.%s.isNormalUser)", a.User, a.User))
+		}
+		if !a.PasswordlessSudo {
+			problems = append(problems, fmt.Sprintf("deploy user %q cannot use sudo without a password (add it to wheel and set security.sudo.wheelNeedsPassword = false)", a.User))
+		}
+	}
+
+	if certPrincipals != nil {
+		if !a.TrustedUserCA {
+			problems = append(problems, "sshd does not trust the inframan CA (TrustedUserCAKeys)")
+		}
+		if !containsString(certPrincipals, a.User) {
+			problems = append(problems, fmt.Sprintf("certificates are issued for %s, not the deploy user %q (set SSH_CERT_PRINCIPALS)",
+				strings.Join(certPrincipals, ", "), a.User))
+		}
+	} else if a.UserKeys == 0 {
+		problems = append(problems, fmt.Sprintf("%s has no authorized SSH keys (set SSH_KEY_PATH or users.users.%s.openssh.authorizedKeys)", a.User, a.User))
+	}
+
+	return problems
+}
+
+// firewallAllows reports whether a TCP port is open in the firewall
+func (a *NodeSSHAccess) firewallAllows(port int) bool {
+	if containsPort(a.AllowedTCPPorts, port) {
+		return true
+	}
+	for _, r := range a.AllowedTCPPortRanges {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPort reports whether port is in ports
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether s is in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalStateFileName is the name of the state file used by Terraform's local backend
+const LocalStateFileName = "terraform.tfstate"
+
+// TerraformState is the subset of the Terraform state format inframan inspects
+type TerraformState struct {
+	Version   int             `json:"version"`
+	Serial    int64           `json:"serial"`
+	Lineage   string          `json:"lineage"`
+	Resources []StateResource `json:"resources"`
+}
+
+// StateResource is a resource block in Terraform state
+type StateResource struct {
+	Module    string                  `json:"module,omitempty"`
+	Mode      string                  `json:"mode"`
+	Type      string                  `json:"type"`
+	Name      string                  `json:"name"`
+	Instances []StateResourceInstance `json:"instances"`
+}
+
+// StateResourceInstance is one instance of a resource (one per count/for_each key)
+type StateResourceInstance struct {
+	IndexKey   interface{}     `json:"index_key,omitempty"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// ParseState decodes Terraform state JSON; empty input (no state yet) is an empty state
+func ParseState(data []byte) (*TerraformState, error) {
+	var state TerraformState
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return &state, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse terraform state: %w", err)
+	}
+	return &state, nil
+}
+
+// Instances returns the attributes of every resource instance keyed by its address
+// (e.g. "aws_instance.web[\"web-1\"]" or "module.net.data.aws_ami.nixos")
+func (s *TerraformState) Instances() map[string]json.RawMessage {
+	instances := make(map[string]json.RawMessage)
+	for _, res := range s.Resources {
+		base := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		if res.Mode == "data" {
+			base = "data." + base
+		}
+		if res.Module != "" {
+			base = res.Module + "." + base
+		}
+
+		for _, inst := range res.Instances {
+			address := base
+			switch key := inst.IndexKey.(type) {
+			case nil:
+			case string:
+				address = fmt.Sprintf("%s[%q]", base, key)
+			case float64:
+				address = fmt.Sprintf("%s[%d]", base, int64(key))
+			default:
+				address = fmt.Sprintf("%s[%v]", base, key)
+			}
+			instances[address] = inst.Attributes
+		}
+	}
+	return instances
+}
+
+// Addresses returns the sorted addresses of all resource instances
+func (s *TerraformState) Addresses() []string {
+	instances := s.Instances()
+	addresses := make([]string, 0, len(instances))
+	for address := range instances {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// LoadLocalState reads the local backend state from the terraform directory,
+// returning nil if there is none
+func LoadLocalState(terraformDir string) (*TerraformState, error) {
+	data, err := os.ReadFile(filepath.Join(terraformDir, LocalStateFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local state: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+	return ParseState(data)
+}
+
+// VerifyMigratedState checks that the state read back from a backend is the
+// state that was migrated: same lineage, no older serial and the same resources
+func VerifyMigratedState(local, remote *TerraformState) error {
+	if remote.Lineage != local.Lineage {
+		return fmt.Errorf("lineage mismatch: local %s, backend %s", local.Lineage, remote.Lineage)
+	}
+	if remote.Serial < local.Serial {
+		return fmt.Errorf("backend state is older than local state: serial %d < %d", remote.Serial, local.Serial)
+	}
+
+	localAddresses := local.Addresses()
+	remoteAddresses := remote.Addresses()
+	if strings.Join(localAddresses, "\n") != strings.Join(remoteAddresses, "\n") {
+		return fmt.Errorf("resource mismatch: local state has %d resource instances, backend has %d",
+			len(localAddresses), len(remoteAddresses))
+	}
+	return nil
+}
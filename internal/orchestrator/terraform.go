@@ -1,11 +1,13 @@
 package orchestrator
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -103,6 +105,70 @@ func ensureInitInDir(terraformDir string) error {
 	return nil
 }
 
+// MigrateState runs terraform init -migrate-state, copying existing state to the
+// backend now configured in config.tf.json without prompting. Any state the backend
+// already holds is overwritten, so callers must check it with PullBackendState first.
+func (t *TerraformExecutor) MigrateState() error {
+	cmd := exec.Command("terraform", "init", "-migrate-state", "-force-copy", "-input=false")
+	cmd.Dir = t.workDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("terraform init -migrate-state failed: %w", err)
+	}
+
+	return nil
+}
+
+// StatePull runs terraform state pull and returns the raw state from the configured backend
+func (t *TerraformExecutor) StatePull() ([]byte, error) {
+	if err := t.EnsureInit(); err != nil {
+		return nil, fmt.Errorf("failed to initialize terraform: %w", err)
+	}
+
+	cmd := exec.Command("terraform", "state", "pull")
+	cmd.Dir = t.workDir
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("terraform state pull failed: %w", err)
+	}
+
+	return output, nil
+}
+
+// StatePush runs terraform state push, replacing the backend's state with the given state.
+// force skips Terraform's lineage and serial checks, which restoring an older state requires.
+func (t *TerraformExecutor) StatePush(data []byte, force bool) error {
+	if err := t.EnsureInit(); err != nil {
+		return fmt.Errorf("failed to initialize terraform: %w", err)
+	}
+
+	args := []string{"state", "push"}
+	if force {
+		args = append(args, "-force")
+	}
+	// "-" reads the state from stdin
+	args = append(args, "-")
+
+	cmd := exec.Command("terraform", args...)
+	cmd.Dir = t.workDir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("terraform state push failed: %w", err)
+	}
+
+	return nil
+}
+
 // Apply runs terraform apply
 func (t *TerraformExecutor) Apply() error {
 	cmd := exec.Command("terraform", "apply")
@@ -185,9 +251,10 @@ func (t *TerraformExecutor) GetWorkDir() string {
 
 // InstanceInfo contains information about a provisioned instance
 type InstanceInfo struct {
-	ProjectName  string
-	InstanceName string // Empty for single-instance projects (legacy public_ip)
-	PublicIP     string
+	ProjectName  string `json:"projectName"`
+	InstanceName string `json:"instanceName"` // Empty for single-instance projects (legacy public_ip)
+	PublicIP     string `json:"publicIP"`
+	Provider     string `json:"provider,omitempty"` // LocalProvider for local VMs, empty for Terraform
 }
 
 // FullName returns the full identifier for the instance (project/instance or just project)
@@ -200,6 +267,11 @@ func (i *InstanceInfo) FullName() string {
 
 // GetInstancesForProject retrieves all instances for a specific project
 func GetInstancesForProject(projectName string) ([]*InstanceInfo, error) {
+	// Local VMs are registered by 'inframan vm start' instead of Terraform
+	if IsLocalProject(projectName) {
+		return getLocalInstances(projectName)
+	}
+
 	terraformDir, err := GetTerraformDirForProject(projectName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get terraform directory: %w", err)
@@ -240,6 +312,12 @@ func GetInstancesForProject(projectName string) ([]*InstanceInfo, error) {
 				PublicIP:     ip,
 			})
 		}
+		// Map iteration order is random; keep listings and deploy order stable
+		sort.Slice(instances, func(i, j int) bool {
+			return instances[i].InstanceName < instances[j].InstanceName
+		})
+		// The cache only speeds up completion, so failing to write it is not an error
+		saveInventoryCache(projectName, instances)
 		return instances, nil
 	}
 
@@ -250,6 +328,7 @@ func GetInstancesForProject(projectName string) ([]*InstanceInfo, error) {
 			InstanceName: "", // Empty for single instance
 			PublicIP:     terraformOutput.PublicIP.Value,
 		})
+		saveInventoryCache(projectName, instances)
 		return instances, nil
 	}
 
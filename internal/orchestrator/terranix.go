@@ -60,6 +60,10 @@ func (t *TerranixExecutor) Build(nixFilePath string) (string, error) {
 		return "", fmt.Errorf("failed to write terraform config: %w", err)
 	}
 
+	if err := applyManagedBackend(outputPath); err != nil {
+		return "", fmt.Errorf("failed to configure backend: %w", err)
+	}
+
 	return outputPath, nil
 }
 
@@ -78,6 +82,11 @@ func (t *TerranixExecutor) BuildFromConfig(configPath string) (string, error) {
 		return "", fmt.Errorf("failed to write config file: %w", err)
 	}
 
+	// Add the project's managed backend, if one is configured
+	if err := applyManagedBackend(outputPath); err != nil {
+		return "", fmt.Errorf("failed to configure backend: %w", err)
+	}
+
 	return outputPath, nil
 }
 
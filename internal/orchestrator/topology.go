@@ -0,0 +1,200 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// TopologyFileName is the name of the topology file written next to hive.nix
+	TopologyFileName = "topology.json"
+
+	// TopologyEtcPath is where the topology is readable on every host
+	TopologyEtcPath = "/etc/inframan/topology.json"
+)
+
+// Topology is what NixOS modules get to know about the project they are deployed in.
+// Modules receive it as the `inframan` module argument, extended with their own
+// instance name and address.
+type Topology struct {
+	Project   string                     `json:"project"`
+	Instances map[string]string          `json:"instances"` // instance name -> address
+	Outputs   map[string]json.RawMessage `json:"outputs"`   // selected Terraform outputs
+}
+
+// terraformOutputValue is one entry of terraform output -json
+type terraformOutputValue struct {
+	Sensitive bool            `json:"sensitive"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// GetNixOutputNames returns the Terraform outputs to expose to NixOS modules from NIX_TF_OUTPUTS
+func GetNixOutputNames() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("NIX_TF_OUTPUTS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// BuildTopology collects the project's nodes and the outputs selected by NIX_TF_OUTPUTS.
+// Sensitive outputs are refused, since the topology ends up in the world-readable Nix store.
+func BuildTopology(projectName string, nodes []*HiveNode) (*Topology, error) {
+	topology := &Topology{
+		Project:   projectName,
+		Instances: make(map[string]string, len(nodes)),
+		Outputs:   make(map[string]json.RawMessage),
+	}
+	for _, node := range nodes {
+		topology.Instances[node.Name] = node.Address
+	}
+
+	names := GetNixOutputNames()
+	if len(names) == 0 {
+		return topology, nil
+	}
+
+	// Local VMs see the outputs of the cloud project, if it has been provisioned
+	outputsProject := projectName
+	if LocalVMsEnabled() {
+		outputsProject = strings.TrimSuffix(projectName, LocalProjectSuffix)
+		terraformDir, err := GetTerraformDirForProject(outputsProject)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(terraformDir); err != nil {
+			return topology, nil
+		}
+	}
+	outputs, err := getTerraformOutputs(outputsProject)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		output, ok := outputs[name]
+		if !ok {
+			return nil, fmt.Errorf("terraform output %q selected in NIX_TF_OUTPUTS not found in project %q", name, projectName)
+		}
+		if output.Sensitive {
+			return nil, fmt.Errorf("terraform output %q is sensitive and cannot be exposed to NixOS modules (ship it as a secret instead)", name)
+		}
+		topology.Outputs[name] = output.Value
+	}
+	return topology, nil
+}
+
+// getTerraformOutputs returns all outputs of a project
+func getTerraformOutputs(projectName string) (map[string]terraformOutputValue, error) {
+	terraformDir, err := GetTerraformDirForProject(projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get terraform directory: %w", err)
+	}
+	if err := ensureInitInDir(terraformDir); err != nil {
+		return nil, fmt.Errorf("failed to initialize terraform for project %q: %w", projectName, err)
+	}
+
+	cmd := exec.Command("terraform", "output", "-json")
+	cmd.Dir = terraformDir
+	cmd.Env = os.Environ()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("terraform output failed for project %q: %w", projectName, err)
+	}
+
+	var outputs map[string]terraformOutputValue
+	if err := json.Unmarshal(output, &outputs); err != nil {
+		return nil, fmt.Errorf("failed to parse terraform output: %w", err)
+	}
+	return outputs, nil
+}
+
+// Differences lists how the topology other differs from this one: the project, instances
+// added, removed or moved to another address, and changed outputs
+func (t *Topology) Differences(other *Topology) []string {
+	var diffs []string
+	if t.Project != other.Project {
+		diffs = append(diffs, fmt.Sprintf("project %s -> %s", t.Project, other.Project))
+	}
+
+	instances := make(map[string]bool)
+	for name := range t.Instances {
+		instances[name] = true
+	}
+	for name := range other.Instances {
+		instances[name] = true
+	}
+	for _, name := range sortedNames(instances) {
+		before, had := t.Instances[name]
+		after, has := other.Instances[name]
+		switch {
+		case !has:
+			diffs = append(diffs, fmt.Sprintf("instance %s removed", name))
+		case !had:
+			diffs = append(diffs, fmt.Sprintf("instance %s added", name))
+		case before != after:
+			diffs = append(diffs, fmt.Sprintf("instance %s %s -> %s", name, before, after))
+		}
+	}
+
+	outputs := make(map[string]bool)
+	for name := range t.Outputs {
+		outputs[name] = true
+	}
+	for name := range other.Outputs {
+		outputs[name] = true
+	}
+	for _, name := range sortedNames(outputs) {
+		before, had := t.Outputs[name]
+		after, has := other.Outputs[name]
+		switch {
+		case !has:
+			diffs = append(diffs, fmt.Sprintf("output %s removed", name))
+		case !had:
+			diffs = append(diffs, fmt.Sprintf("output %s added", name))
+		case !jsonEqual(before, after):
+			diffs = append(diffs, fmt.Sprintf("output %s changed", name))
+		}
+	}
+	return diffs
+}
+
+// sortedNames returns the names in a set, sorted
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonEqual reports whether two JSON values are equal, ignoring formatting
+func jsonEqual(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// writeTopology writes the topology into a deployer's working directory, where the
+// generated node definitions read it from
+func writeTopology(dir string, topology *Topology) error {
+	data, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode topology: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TopologyFileName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", TopologyFileName, err)
+	}
+	return nil
+}
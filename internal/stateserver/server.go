@@ -0,0 +1,420 @@
+// Package stateserver implements Terraform's HTTP backend protocol, storing
+// state per project on local disk with versioning and locking.
+package stateserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StateFileName is the name of the current state file of a project
+	StateFileName = "terraform.tfstate"
+
+	// LockFileName is the name of the lock metadata file of a project
+	LockFileName = "lock.json"
+
+	// VersionsSubdir is the subdirectory holding previous state versions
+	VersionsSubdir = "versions"
+
+	// DefaultMaxVersions is the number of previous state versions kept per project
+	DefaultMaxVersions = 50
+
+	// methodLock and methodUnlock are the HTTP methods Terraform uses for locking
+	methodLock   = "LOCK"
+	methodUnlock = "UNLOCK"
+)
+
+// projectNamePattern restricts project paths to safe directory names
+var projectNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// LockInfo is the lock metadata Terraform sends with LOCK and UNLOCK requests
+type LockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
+// Version describes a stored previous version of a project's state
+type Version struct {
+	Name    string    `json:"name"`
+	Serial  int64     `json:"serial"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Options configures a state server
+type Options struct {
+	// DataDir is the directory holding one subdirectory per project
+	DataDir string
+
+	// Username and Password enable HTTP basic auth when Username is set
+	Username string
+	Password string
+
+	// MaxVersions is the number of previous versions kept per project (0 uses the default)
+	MaxVersions int
+
+	// Logger receives one line per request; nil disables logging
+	Logger *log.Logger
+}
+
+// Server serves Terraform state over HTTP
+type Server struct {
+	opts Options
+	mu   sync.Mutex
+}
+
+// New creates a state server
+func New(opts Options) (*Server, error) {
+	if opts.DataDir == "" {
+		return nil, fmt.Errorf("data directory is required")
+	}
+	if opts.MaxVersions <= 0 {
+		opts.MaxVersions = DefaultMaxVersions
+	}
+	if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", opts.DataDir, err)
+	}
+	return &Server{opts: opts}, nil
+}
+
+// ServeHTTP implements http.Handler. The request path is the project name;
+// nested paths such as /team/project are allowed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Logger != nil {
+		s.opts.Logger.Printf("%s %s", r.Method, r.URL.Path)
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="inframan state"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	projectDir, err := s.projectDir(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := r.URL.Query()["versions"]; ok {
+			s.handleListVersions(w, projectDir)
+			return
+		}
+		s.handleGet(w, r, projectDir)
+	case http.MethodPost:
+		s.handlePost(w, r, projectDir)
+	case http.MethodDelete:
+		s.handleDelete(w, projectDir)
+	case methodLock:
+		s.handleLock(w, r, projectDir)
+	case methodUnlock:
+		s.handleUnlock(w, r, projectDir)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks basic auth credentials when they are configured
+func (s *Server) authorized(r *http.Request) bool {
+	if s.opts.Username == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.opts.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.opts.Password)) == 1
+	return userOK && passOK
+}
+
+// projectDir maps a request path to the project's data directory
+func (s *Server) projectDir(urlPath string) (string, error) {
+	name := strings.Trim(urlPath, "/")
+	if name == "" {
+		return "", fmt.Errorf("project name is required in the path")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if !projectNamePattern.MatchString(segment) {
+			return "", fmt.Errorf("invalid project name %q", name)
+		}
+	}
+	return filepath.Join(s.opts.DataDir, filepath.FromSlash(name)), nil
+}
+
+// handleGet returns the current state, or a stored version with ?version=<name>
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, projectDir string) {
+	path := filepath.Join(projectDir, StateFileName)
+	if version := r.URL.Query().Get("version"); version != "" {
+		if !projectNamePattern.MatchString(version) {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		path = filepath.Join(projectDir, VersionsSubdir, version)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Terraform treats 404 as "no state yet"
+		http.Error(w, "no state", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handlePost stores a new state, keeping the previous one as a version
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, projectDir string) {
+	lock, err := s.readLock(projectDir)
+	if err != nil {
+		http.Error(w, "failed to read lock", http.StatusInternalServerError)
+		return
+	}
+	// Terraform passes the lock ID as ?ID= when it holds the lock
+	if lock != nil && r.URL.Query().Get("ID") != lock.ID {
+		writeLockConflict(w, lock)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	var state struct {
+		Serial int64 `json:"serial"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		http.Error(w, "state is not valid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Join(projectDir, VersionsSubdir), 0700); err != nil {
+		http.Error(w, "failed to create project directory", http.StatusInternalServerError)
+		return
+	}
+
+	// Version the new state immediately so every write can be restored
+	versionName := fmt.Sprintf("%s-serial%d.tfstate", time.Now().UTC().Format("20060102T150405.000000000Z"), state.Serial)
+	if err := writeFileAtomic(filepath.Join(projectDir, VersionsSubdir, versionName), data); err != nil {
+		http.Error(w, "failed to write state version", http.StatusInternalServerError)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(projectDir, StateFileName), data); err != nil {
+		http.Error(w, "failed to write state", http.StatusInternalServerError)
+		return
+	}
+
+	s.pruneVersions(projectDir)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDelete removes the current state; stored versions are kept
+func (s *Server) handleDelete(w http.ResponseWriter, projectDir string) {
+	if err := os.Remove(filepath.Join(projectDir, StateFileName)); err != nil && !os.IsNotExist(err) {
+		http.Error(w, "failed to delete state", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleLock acquires the project lock or reports the current holder
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request, projectDir string) {
+	var info LockInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, "invalid lock info", http.StatusBadRequest)
+		return
+	}
+
+	current, err := s.readLock(projectDir)
+	if err != nil {
+		http.Error(w, "failed to read lock", http.StatusInternalServerError)
+		return
+	}
+	if current != nil {
+		writeLockConflict(w, current)
+		return
+	}
+
+	if err := os.MkdirAll(projectDir, 0700); err != nil {
+		http.Error(w, "failed to create project directory", http.StatusInternalServerError)
+		return
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		http.Error(w, "failed to encode lock", http.StatusInternalServerError)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(projectDir, LockFileName), data); err != nil {
+		http.Error(w, "failed to write lock", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleUnlock releases the project lock if the request carries the holder's ID
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request, projectDir string) {
+	var info LockInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, "invalid lock info", http.StatusBadRequest)
+		return
+	}
+
+	current, err := s.readLock(projectDir)
+	if err != nil {
+		http.Error(w, "failed to read lock", http.StatusInternalServerError)
+		return
+	}
+	if current == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if info.ID != current.ID {
+		writeLockConflict(w, current)
+		return
+	}
+
+	if err := os.Remove(filepath.Join(projectDir, LockFileName)); err != nil && !os.IsNotExist(err) {
+		http.Error(w, "failed to remove lock", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleListVersions returns the stored versions of a project, newest first
+func (s *Server) handleListVersions(w http.ResponseWriter, projectDir string) {
+	versions, err := listVersions(projectDir)
+	if err != nil {
+		http.Error(w, "failed to list versions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// readLock returns the current lock of a project, or nil if it is unlocked
+func (s *Server) readLock(projectDir string) (*LockInfo, error) {
+	data, err := os.ReadFile(filepath.Join(projectDir, LockFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// pruneVersions removes the oldest versions beyond the configured maximum
+func (s *Server) pruneVersions(projectDir string) {
+	versions, err := listVersions(projectDir)
+	if err != nil || len(versions) <= s.opts.MaxVersions {
+		return
+	}
+	for _, v := range versions[s.opts.MaxVersions:] {
+		os.Remove(filepath.Join(projectDir, VersionsSubdir, v.Name))
+	}
+}
+
+// listVersions returns the stored versions of a project, newest first
+func listVersions(projectDir string) ([]Version, error) {
+	entries, err := os.ReadDir(filepath.Join(projectDir, VersionsSubdir))
+	if os.IsNotExist(err) {
+		return []Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tfstate") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		var serial int64
+		if i := strings.Index(entry.Name(), "-serial"); i >= 0 {
+			fmt.Sscanf(entry.Name()[i:], "-serial%d.tfstate", &serial)
+		}
+		versions = append(versions, Version{
+			Name:    entry.Name(),
+			Serial:  serial,
+			Size:    info.Size(),
+			Created: info.ModTime(),
+		})
+	}
+
+	// Names start with a sortable UTC timestamp
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Name > versions[j].Name
+	})
+	return versions, nil
+}
+
+// writeLockConflict reports the current lock holder the way Terraform expects
+func writeLockConflict(w http.ResponseWriter, lock *LockInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	json.NewEncoder(w).Encode(lock)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
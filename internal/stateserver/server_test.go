@@ -0,0 +1,239 @@
+package stateserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer starts a state server on a temporary data directory
+func newTestServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	opts.DataDir = t.TempDir()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request and returns the response status and body
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func lockBody(id string) string {
+	return fmt.Sprintf(`{"ID":%q,"Operation":"OperationTypeApply","Who":"alice@host"}`, id)
+}
+
+func TestGetWithoutState(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	if status, _ := do(t, http.MethodGet, ts.URL+"/prod", ""); status != http.StatusNotFound {
+		t.Fatalf("GET without state: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPostAndGet(t *testing.T) {
+	ts := newTestServer(t, Options{})
+	state := `{"version":4,"serial":1,"lineage":"abc"}`
+
+	if status, body := do(t, http.MethodPost, ts.URL+"/prod", state); status != http.StatusOK {
+		t.Fatalf("POST: status %d: %s", status, body)
+	}
+	status, body := do(t, http.MethodGet, ts.URL+"/prod", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET: status %d: %s", status, body)
+	}
+	if body != state {
+		t.Fatalf("GET returned %q, want %q", body, state)
+	}
+
+	// Projects are stored separately
+	if status, _ := do(t, http.MethodGet, ts.URL+"/staging", ""); status != http.StatusNotFound {
+		t.Fatalf("GET other project: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPostRejectsInvalidState(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	if status, _ := do(t, http.MethodPost, ts.URL+"/prod", "not json"); status != http.StatusBadRequest {
+		t.Fatalf("POST invalid state: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestInvalidProjectName(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	for _, path := range []string{"/", "/..%2Fescape", "/team/.hidden"} {
+		if status, _ := do(t, http.MethodGet, ts.URL+path, ""); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want %d", path, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLockAndUnlock(t *testing.T) {
+	ts := newTestServer(t, Options{})
+	url := ts.URL + "/prod"
+
+	if status, body := do(t, methodLock, url, lockBody("one")); status != http.StatusOK {
+		t.Fatalf("LOCK: status %d: %s", status, body)
+	}
+
+	// A second lock reports the current holder
+	status, body := do(t, methodLock, url, lockBody("two"))
+	if status != http.StatusLocked {
+		t.Fatalf("second LOCK: status %d, want %d", status, http.StatusLocked)
+	}
+	var holder LockInfo
+	if err := json.Unmarshal([]byte(body), &holder); err != nil {
+		t.Fatalf("failed to parse lock conflict body %q: %v", body, err)
+	}
+	if holder.ID != "one" || holder.Who != "alice@host" {
+		t.Fatalf("lock conflict reports %+v, want holder one", holder)
+	}
+
+	// Only the holder may unlock
+	if status, _ := do(t, methodUnlock, url, lockBody("two")); status != http.StatusLocked {
+		t.Fatalf("UNLOCK with other ID: status %d, want %d", status, http.StatusLocked)
+	}
+	if status, body := do(t, methodUnlock, url, lockBody("one")); status != http.StatusOK {
+		t.Fatalf("UNLOCK: status %d: %s", status, body)
+	}
+	if status, body := do(t, methodLock, url, lockBody("two")); status != http.StatusOK {
+		t.Fatalf("LOCK after UNLOCK: status %d: %s", status, body)
+	}
+}
+
+func TestUnlockWithoutLock(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	if status, body := do(t, methodUnlock, ts.URL+"/prod", lockBody("one")); status != http.StatusOK {
+		t.Fatalf("UNLOCK without lock: status %d: %s", status, body)
+	}
+}
+
+func TestPostWhileLocked(t *testing.T) {
+	ts := newTestServer(t, Options{})
+	url := ts.URL + "/prod"
+	state := `{"version":4,"serial":2,"lineage":"abc"}`
+
+	if status, body := do(t, methodLock, url, lockBody("one")); status != http.StatusOK {
+		t.Fatalf("LOCK: status %d: %s", status, body)
+	}
+	if status, _ := do(t, http.MethodPost, url, state); status != http.StatusLocked {
+		t.Fatalf("POST without lock ID: status %d, want %d", status, http.StatusLocked)
+	}
+	if status, _ := do(t, http.MethodPost, url+"?ID=two", state); status != http.StatusLocked {
+		t.Fatalf("POST with other lock ID: status %d, want %d", status, http.StatusLocked)
+	}
+	if status, body := do(t, http.MethodPost, url+"?ID=one", state); status != http.StatusOK {
+		t.Fatalf("POST with lock ID: status %d: %s", status, body)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	ts := newTestServer(t, Options{MaxVersions: 2})
+	url := ts.URL + "/prod"
+
+	for serial := 1; serial <= 3; serial++ {
+		state := fmt.Sprintf(`{"version":4,"serial":%d,"lineage":"abc"}`, serial)
+		if status, body := do(t, http.MethodPost, url, state); status != http.StatusOK {
+			t.Fatalf("POST serial %d: status %d: %s", serial, status, body)
+		}
+	}
+
+	status, body := do(t, http.MethodGet, url+"?versions", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET versions: status %d: %s", status, body)
+	}
+	var versions []Version
+	if err := json.Unmarshal([]byte(body), &versions); err != nil {
+		t.Fatalf("failed to parse versions %q: %v", body, err)
+	}
+	// The oldest version is pruned; the rest are listed newest first
+	if len(versions) != 2 || versions[0].Serial != 3 || versions[1].Serial != 2 {
+		t.Fatalf("versions = %+v, want serials 3 and 2", versions)
+	}
+
+	status, body = do(t, http.MethodGet, url+"?version="+versions[1].Name, "")
+	if status != http.StatusOK || !strings.Contains(body, `"serial":2`) {
+		t.Fatalf("GET version %s: status %d: %s", versions[1].Name, status, body)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ts := newTestServer(t, Options{})
+	url := ts.URL + "/prod"
+
+	if status, body := do(t, http.MethodPost, url, `{"serial":1}`); status != http.StatusOK {
+		t.Fatalf("POST: status %d: %s", status, body)
+	}
+	if status, body := do(t, http.MethodDelete, url, ""); status != http.StatusOK {
+		t.Fatalf("DELETE: status %d: %s", status, body)
+	}
+	if status, _ := do(t, http.MethodGet, url, ""); status != http.StatusNotFound {
+		t.Fatalf("GET after DELETE: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	ts := newTestServer(t, Options{Username: "terraform", Password: "secret"})
+
+	if status, _ := do(t, http.MethodGet, ts.URL+"/prod", ""); status != http.StatusUnauthorized {
+		t.Fatalf("GET without credentials: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/prod", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.SetBasicAuth("terraform", "wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET with wrong password: status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req.SetBasicAuth("terraform", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET with credentials: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	if status, _ := do(t, http.MethodPut, ts.URL+"/prod", ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT: status %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+}
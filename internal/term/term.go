@@ -0,0 +1,14 @@
+// Package term provides the minimal terminal handling inframan needs:
+// raw mode, window sizes and pseudo-terminals for recorded sessions.
+package term
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without terminal support
+var ErrUnsupported = errors.New("terminal operations are not supported on this platform")
+
+// Size is a terminal window size in character cells
+type Size struct {
+	Rows uint16
+	Cols uint16
+}
@@ -0,0 +1,113 @@
+//go:build linux
+
+package term
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// State holds the terminal attributes to restore after raw mode
+type State struct {
+	termios syscall.Termios
+}
+
+// winsize mirrors struct winsize from <sys/ioctl.h>
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal reports whether fd refers to a terminal
+func IsTerminal(fd int) bool {
+	var termios syscall.Termios
+	return ioctl(uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// MakeRaw puts the terminal into raw mode and returns the previous state
+func MakeRaw(fd int) (*State, error) {
+	var old syscall.Termios
+	if err := ioctl(uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, fmt.Errorf("failed to get terminal attributes: %w", err)
+	}
+
+	// Same settings as cfmakeraw(3)
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, fmt.Errorf("failed to set terminal attributes: %w", err)
+	}
+	return &State{termios: old}, nil
+}
+
+// Restore returns the terminal to a state saved by MakeRaw
+func Restore(fd int, state *State) error {
+	if err := ioctl(uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(&state.termios))); err != nil {
+		return fmt.Errorf("failed to restore terminal attributes: %w", err)
+	}
+	return nil
+}
+
+// GetSize returns the window size of the terminal
+func GetSize(fd int) (Size, error) {
+	var ws winsize
+	if err := ioctl(uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return Size{}, fmt.Errorf("failed to get window size: %w", err)
+	}
+	return Size{Rows: ws.Row, Cols: ws.Col}, nil
+}
+
+// SetSize sets the window size of the terminal
+func SetSize(fd int, size Size) error {
+	ws := winsize{Row: size.Rows, Col: size.Cols}
+	if err := ioctl(uintptr(fd), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return fmt.Errorf("failed to set window size: %w", err)
+	}
+	return nil
+}
+
+// OpenPTY allocates a pseudo-terminal and returns its master and slave ends
+func OpenPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pty slave: %w", err)
+	}
+	return master, slave, nil
+}
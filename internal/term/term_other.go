@@ -0,0 +1,44 @@
+//go:build !linux && !darwin
+
+package term
+
+import "os"
+
+// State holds the terminal attributes to restore after raw mode
+type State struct{}
+
+// IsTerminal reports whether fd refers to a terminal
+func IsTerminal(fd int) bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		if int(f.Fd()) == fd {
+			info, err := f.Stat()
+			return err == nil && info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return false
+}
+
+// MakeRaw puts the terminal into raw mode and returns the previous state
+func MakeRaw(fd int) (*State, error) {
+	return nil, ErrUnsupported
+}
+
+// Restore returns the terminal to a state saved by MakeRaw
+func Restore(fd int, state *State) error {
+	return ErrUnsupported
+}
+
+// GetSize returns the window size of the terminal
+func GetSize(fd int) (Size, error) {
+	return Size{}, ErrUnsupported
+}
+
+// SetSize sets the window size of the terminal
+func SetSize(fd int, size Size) error {
+	return ErrUnsupported
+}
+
+// OpenPTY allocates a pseudo-terminal and returns its master and slave ends
+func OpenPTY() (master, slave *os.File, err error) {
+	return nil, nil, ErrUnsupported
+}